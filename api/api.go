@@ -13,11 +13,13 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func getRecords(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
-	records, err := rs.GetRecords(r.Context(), username)
+	records, serial, err := rs.GetRecordsAndSerial(r.Context(), username)
 	if err != nil {
 		returnError(w, r, err, err.Code)
 		return
@@ -28,13 +30,61 @@ func getRecords(username string, rs records.RecordService, w http.ResponseWriter
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(serial))
 	w.Write(jsonOutput)
 }
 
+// the ETag for the records is the zone's SOA serial, since that changes
+// every time the zone does
+func etag(serial uint32) string {
+	return fmt.Sprintf("\"%d\"", serial)
+}
+
+// withIfMatch reads the If-Match header, so that edits fail if the zone has
+// changed since the client got its ETag. The header can be a list of ETags
+// (the edit goes through if any of them match), and weak ETags (W/"123")
+// are fine too, since some proxies weaken ETags when they compress responses.
+func withIfMatch(r *http.Request) (*http.Request, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return r, nil
+	}
+	var serials []uint32
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		serial, err := strconv.ParseUint(strings.Trim(tag, "\""), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid If-Match header: %s", header)
+		}
+		serials = append(serials, uint32(serial))
+	}
+	return r.WithContext(records.IfMatch(r.Context(), serials...)), nil
+}
+
+// returnEditError is returnError for edits. If the edit failed because
+// someone else changed the zone first, we send the current records along
+// with the error (in "details") so that the client can show them.
+func returnEditError(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request, err *records.HTTPError) {
+	if err.Code != http.StatusConflict {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	logMsg(r, fmt.Sprintf("Error [%d]: %s\n", err.Code, err.Error()))
+	current, serial, err2 := rs.GetRecordsAndSerial(r.Context(), username)
+	if err2 != nil {
+		returnError(w, r, err2, err2.Code)
+		return
+	}
+	conflict := *err
+	conflict.Details = map[string]any{"records": current}
+	w.Header().Set("ETag", etag(serial))
+	writeError(w, &conflict)
+}
+
 func deleteRecord(username string, id string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
 	err := rs.DeleteRecord(r.Context(), username, id)
 	if err != nil {
-		returnError(w, r, err, err.Code)
+		returnEditError(username, rs, w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -43,7 +93,7 @@ func deleteRecord(username string, id string, rs records.RecordService, w http.R
 func deleteAllRecords(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
 	err := rs.DeleteAllRecords(r.Context(), username)
 	if err != nil {
-		returnError(w, r, err, err.Code)
+		returnEditError(username, rs, w, r, err)
 		return
 	}
 	_, err2 := rs.CreateZone(r.Context(), username)
@@ -67,10 +117,10 @@ func updateRecord(username string, id string, rs records.RecordService, w http.R
 	}
 	err2 := rs.UpdateRecord(r.Context(), username, id, record)
 	if err2 != nil {
-		returnError(w, r, err2, err2.Code)
+		returnEditError(username, rs, w, r, err2)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeWarnings(username, record, rs, w, r)
 }
 
 func createRecord(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
@@ -85,6 +135,273 @@ func createRecord(username string, rs records.RecordService, w http.ResponseWrit
 		return
 	}
 	err2 := rs.CreateRecord(r.Context(), username, record)
+	if err2 != nil {
+		returnEditError(username, rs, w, r, err2)
+		return
+	}
+	writeWarnings(username, record, rs, w, r)
+}
+
+// writeWarnings is the response for a successful create or update: the
+// record worked, but here's anything that looks wrong about it
+func writeWarnings(username string, record map[string]string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, struct {
+		Warnings []records.Warning `json:"warnings"`
+	}{rs.LintRecord(r.Context(), username, record)})
+}
+
+func lintRecords(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	warnings, err := rs.LintRecords(r.Context(), username)
+	if err != nil {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	writeJSON(w, r, warnings)
+}
+
+func syncZone(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	// the same format as GET /records, so you can edit its output and PUT it back
+	desired := []struct {
+		Record map[string]any `json:"record"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error reading body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &desired); err != nil {
+		returnError(w, r, fmt.Errorf("error decoding json: %s, body: %s", err.Error(), string(body)), http.StatusBadRequest)
+		return
+	}
+	records := []map[string]string{}
+	for _, d := range desired {
+		records = append(records, recordStrings(d.Record))
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	plan, err2 := rs.SyncZone(r.Context(), username, records, dryRun)
+	if err2 != nil {
+		returnEditError(username, rs, w, r, err2)
+		return
+	}
+	writeJSON(w, r, plan)
+}
+
+// recordStrings turns the values that aren't strings (like "strings" in GET
+// /records' output, or a TTL that's a number) into JSON text
+func recordStrings(record map[string]any) map[string]string {
+	m := map[string]string{}
+	for k, v := range record {
+		if s, ok := v.(string); ok {
+			m[k] = s
+			continue
+		}
+		data, _ := json.Marshal(v)
+		m[k] = string(data)
+	}
+	return m
+}
+
+func getHistory(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	changes, err := rs.GetHistory(r.Context(), username)
+	if err != nil {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	writeJSON(w, r, changes)
+}
+
+func revertChange(username string, id string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	changeID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("invalid change ID: %s", id), http.StatusBadRequest)
+		return
+	}
+	err2 := rs.RevertChange(r.Context(), username, changeID)
+	if err2 != nil {
+		returnEditError(username, rs, w, r, err2)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func getPresets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, records.Presets)
+}
+
+func applyPreset(username string, name string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	result, err := rs.ApplyPreset(r.Context(), username, name, r.URL.Query().Get("subdomain"))
+	if err != nil {
+		returnEditError(username, rs, w, r, err)
+		return
+	}
+	if len(result.Conflicts) > 0 {
+		first := result.Conflicts[0]
+		returnError(w, r, &records.HTTPError{
+			Code:      http.StatusBadRequest,
+			ErrorCode: first.Code,
+			Message:   first.Error,
+			Details:   map[string]any{"conflicts": result.Conflicts},
+		}, http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, result)
+}
+
+func getSnapshots(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	snapshots, err := rs.GetSnapshots(r.Context(), username)
+	if err != nil {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	writeJSON(w, r, snapshots)
+}
+
+func saveSnapshot(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error reading body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		returnError(w, r, fmt.Errorf("error decoding json: %s, body: %s", err.Error(), string(body)), http.StatusBadRequest)
+		return
+	}
+	snapshot, err2 := rs.SaveSnapshot(r.Context(), username, request.Name)
+	if err2 != nil {
+		returnError(w, r, err2, err2.Code)
+		return
+	}
+	writeJSON(w, r, snapshot)
+}
+
+// restoreSnapshot is also how you diff a snapshot against your records: with
+// dryRun it only returns what restoring it would change
+func restoreSnapshot(username string, id string, dryRun bool, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	snapshotID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("invalid snapshot ID: %s", id), http.StatusBadRequest)
+		return
+	}
+	plan, err2 := rs.RestoreSnapshot(r.Context(), username, snapshotID, dryRun)
+	if err2 != nil {
+		returnEditError(username, rs, w, r, err2)
+		return
+	}
+	writeJSON(w, r, plan)
+}
+
+func deleteSnapshot(username string, id string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	snapshotID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("invalid snapshot ID: %s", id), http.StatusBadRequest)
+		return
+	}
+	err2 := rs.DeleteSnapshot(r.Context(), username, snapshotID)
+	if err2 != nil {
+		returnError(w, r, err2, err2.Code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func exportRecords(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	exported, err := rs.ExportRecords(r.Context(), username)
+	if err != nil {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	filename := strings.TrimSuffix(username+"."+records.TLD, ".")
+	var output []byte
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "zone":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		filename += ".zone"
+		output = []byte(records.ZoneFile(username, exported))
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		filename += ".json"
+		jsonOutput, err := json.Marshal(exported)
+		if err != nil {
+			returnError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		output = jsonOutput
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		filename += ".csv"
+		csvOutput, err := records.CSV(exported)
+		if err != nil {
+			returnError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		output = []byte(csvOutput)
+	default:
+		returnError(w, r, fmt.Errorf("unknown export format: %s", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(output)
+}
+
+func importRecords(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	// zone files are plain text, not JSON
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error reading body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	result, err2 := rs.ImportRecords(r.Context(), username, string(body))
+	if err2 != nil {
+		returnEditError(username, rs, w, r, err2)
+		return
+	}
+	if len(result.Errors) > 0 {
+		first := result.Errors[0]
+		code := first.Code
+		if code == "" {
+			code = records.CodeInvalidRequest
+		}
+		returnError(w, r, &records.HTTPError{
+			Code:      http.StatusBadRequest,
+			ErrorCode: code,
+			Message:   first.Error(),
+			Details:   map[string]any{"errors": result.Errors},
+		}, http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, result)
+}
+
+func getDNSSEC(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	status, err := rs.GetDNSSEC(r.Context(), username)
+	if err != nil {
+		returnError(w, r, err, err.Code)
+		return
+	}
+	jsonOutput, err2 := json.Marshal(status)
+	if err2 != nil {
+		returnError(w, r, err2, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonOutput)
+}
+
+func setDNSSEC(username string, rs records.RecordService, w http.ResponseWriter, r *http.Request) {
+	settings := records.DNSSECSettings{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error reading body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &settings); err != nil {
+		returnError(w, r, fmt.Errorf("error decoding json: %s, body: %s", err.Error(), string(body)), http.StatusBadRequest)
+		return
+	}
+	err2 := rs.SetDNSSEC(r.Context(), username, settings)
 	if err2 != nil {
 		returnError(w, r, err2, err2.Code)
 		return
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNS-over-HTTPS (RFC 8484). We just unpack the query, send it through the
+// same ServeDNS code path as UDP/TCP queries, and write the response back
+// as the HTTP response body.
+
+const dnsMessageContentType = "application/dns-message"
+
+// dohResponseWriter is a dns.ResponseWriter that saves the response so that
+// we can write it back over HTTP
+type dohResponseWriter struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	msg        *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return w.localAddr }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+func (w *dohResponseWriter) Close() error         { return nil }
+func (w *dohResponseWriter) TsigStatus() error    { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool)  {}
+func (w *dohResponseWriter) Hijack()              {}
+
+// Transport tells the logger that this query came in over DoH
+func (w *dohResponseWriter) Transport() string { return "doh" }
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	err := m.Unpack(b)
+	if err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func dohRemoteAddr(r *http.Request) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(clientIP(r))}
+}
+
+func readDoHQuery(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodGet {
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			return nil, fmt.Errorf("missing dns query parameter")
+		}
+		// the RFC says no padding, but accept it anyway
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+	}
+	if r.Header.Get("Content-Type") != dnsMessageContentType {
+		return nil, fmt.Errorf("Content-Type must be %s", dnsMessageContentType)
+	}
+	return io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+}
+
+func (handle *handler) dnsQuery(w http.ResponseWriter, r *http.Request) {
+	wire, err := readDoHQuery(r)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error reading DNS query: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	query := new(dns.Msg)
+	err = query.Unpack(wire)
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error parsing DNS query: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(query.Question) != 1 {
+		returnError(w, r, fmt.Errorf("expected 1 question, got %d", len(query.Question)), http.StatusBadRequest)
+		return
+	}
+	dw := &dohResponseWriter{
+		localAddr:  &net.TCPAddr{},
+		remoteAddr: dohRemoteAddr(r),
+	}
+	handle.ServeDNS(dw, query)
+	if dw.msg == nil {
+		// ServeDNS always writes a response, unless the query got rate
+		// limited and we're dropping those
+		returnError(w, r, fmt.Errorf("too many DNS queries, try again later"), http.StatusTooManyRequests)
+		return
+	}
+	response, err := dw.msg.Pack()
+	if err != nil {
+		returnError(w, r, fmt.Errorf("error packing DNS response: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageContentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(dw.msg)))
+	w.Write(response)
+}
+
+// minTTL is how long an HTTP cache is allowed to keep a DoH response
+func minTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
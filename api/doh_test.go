@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/ratelimit"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func packQuery(t *testing.T, name string) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.Id = 0
+	wire, err := m.Pack()
+	fatalIfErr(t, err)
+	return wire
+}
+
+func readDoHResponse(t *testing.T, resp *http.Response) *dns.Msg {
+	body, err := io.ReadAll(resp.Body)
+	fatalIfErr(t, err)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Error %d: %s", resp.StatusCode, body)
+	}
+	assert.Equal(t, "application/dns-message", resp.Header.Get("Content-Type"))
+	m := new(dns.Msg)
+	fatalIfErr(t, m.Unpack(body))
+	return m
+}
+
+func TestDoH(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+	ts := createTestServerWithHandler(handler)
+	defer ts.Close()
+
+	// GET
+	query := base64.RawURLEncoding.EncodeToString(packQuery(t, "orange.messwithdns.com."))
+	resp, err := ts.Client().Get(ts.URL + "/dns-query?dns=" + query)
+	fatalIfErr(t, err)
+	m := readDoHResponse(t, resp)
+	assert.Equal(t, "1.2.3.4", m.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+
+	// POST
+	resp, err = ts.Client().Post(ts.URL+"/dns-query", "application/dns-message", bytes.NewReader(packQuery(t, "www.orange.messwithdns.com.")))
+	fatalIfErr(t, err)
+	m = readDoHResponse(t, resp)
+	assert.Equal(t, "www.orange.messwithdns.com.", m.Answer[0].Header().Name)
+
+	logs, err := handler.logger.GetRequests(context.Background(), "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, 2, len(logs))
+	for _, log := range logs {
+		assert.Equal(t, "doh", log.Request.Transport)
+		assert.Equal(t, "127.0.0.1", log.Request.SourceIP)
+	}
+}
+
+func TestDoHBadRequest(t *testing.T) {
+	ts := createTestServer(t)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/dns-query")
+	fatalIfErr(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = ts.Client().Post(ts.URL+"/dns-query", "application/dns-message", bytes.NewReader([]byte("not dns")))
+	fatalIfErr(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDoHClientIP(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+	handler.limiter = ratelimit.New(ratelimit.Config{QPS: 1, Burst: 1})
+	handler.dropLimited = true
+	handler.behindProxy = true
+	ts := createTestServerWithHandler(handler)
+	defer ts.Close()
+
+	// the first X-Forwarded-For address comes from the client, so it
+	// shouldn't get its own rate limit
+	send := func(spoofed string) *http.Response {
+		r, err := http.NewRequest("POST", ts.URL+"/dns-query", bytes.NewReader(packQuery(t, "orange.messwithdns.com.")))
+		fatalIfErr(t, err)
+		r.Header.Set("Content-Type", "application/dns-message")
+		r.Header.Set("X-Forwarded-For", spoofed+", 192.0.2.1")
+		resp, err := ts.Client().Do(r)
+		fatalIfErr(t, err)
+		return resp
+	}
+	readDoHResponse(t, send("198.51.100.1"))
+	resp := send("198.51.100.2")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	logs, err := handler.logger.GetRequests(context.Background(), "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, "192.0.2.1", logs[0].Request.SourceIP)
+}
+
+func TestClientIP(t *testing.T) {
+	var got string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	})
+	clientIPFor := func(handler http.Handler, headers map[string]string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+	behindProxy := proxyMiddleware(handler)
+	assert.Equal(t, "10.0.0.1", clientIPFor(behindProxy, nil))
+	assert.Equal(t, "10.0.0.1", clientIPFor(behindProxy, map[string]string{"X-Forwarded-For": "banana"}))
+	assert.Equal(t, "192.0.2.1", clientIPFor(behindProxy, map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.1"}))
+	assert.Equal(t, "192.0.2.2", clientIPFor(behindProxy, map[string]string{"X-Forwarded-For": "192.0.2.1", "Fly-Client-IP": "192.0.2.2"}))
+
+	// if we're not behind a proxy, anyone can set the headers
+	assert.Equal(t, "10.0.0.1", clientIPFor(handler, map[string]string{"X-Forwarded-For": "192.0.2.1", "Fly-Client-IP": "192.0.2.2"}))
+}
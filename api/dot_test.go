@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSelfSignedCert writes a certificate for localhost and returns the
+// paths to the certificate and key
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fatalIfErr(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	fatalIfErr(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	fatalIfErr(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	fatalIfErr(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	fatalIfErr(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestDoT(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+
+	tlsConfig, err := loadTLSConfig(writeSelfSignedCert(t))
+	fatalIfErr(t, err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	fatalIfErr(t, err)
+	srv := &dns.Server{Listener: ln, Handler: handler, Net: "tcp-tls"}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	c := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	m := new(dns.Msg)
+	m.SetQuestion("orange.messwithdns.com.", dns.TypeA)
+	response, _, err := c.Exchange(m, ln.Addr().String())
+	fatalIfErr(t, err)
+	assert.Equal(t, "1.2.3.4", response.Answer[0].(*dns.A).A.String())
+
+	// the request gets logged after the response is sent
+	assert.Eventually(t, func() bool {
+		logs, err := handler.logger.GetRequests(context.Background(), "orange")
+		return err == nil && len(logs) == 1 && logs[0].Request.Transport == "dot"
+	}, 2*time.Second, 10*time.Millisecond)
+}
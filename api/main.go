@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,10 +20,13 @@ import (
 
 	"github.com/honeycombio/honeycomb-opentelemetry-go"
 	"github.com/honeycombio/otel-config-go/otelconfig"
+	"github.com/jvns/mess-with-dns/metrics"
+	"github.com/jvns/mess-with-dns/ratelimit"
 	"github.com/jvns/mess-with-dns/records"
 	"github.com/jvns/mess-with-dns/streamer"
 	"github.com/jvns/mess-with-dns/users"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -32,13 +38,44 @@ type Config struct {
 	workdir           string // where to read ip2asn files and static files from
 	requestDBFilename string
 	userDBFilename    string
+	// where we keep the history of everyone's record changes
+	historyDBFilename string
 	// for cookies
 	hashKey  string
 	blockKey string
 	// address of powerdns API
 	powerdnsAddress string
+	// address of the powerdns DNS server we proxy queries to
+	upstreamAddress string
+	// "powerdns" or "local". With "local", records are kept in this process
+	// (and in localRecordsDBFilename, if it's set) and we answer DNS queries
+	// ourselves instead of proxying them to powerdns.
+	recordsBackend         string
+	localRecordsDBFilename string
+	// zone file for messwithdns.com itself, for the local backend
+	zoneFile string
+	// resolver ALIAS targets get looked up with in local mode. PowerDNS gets
+	// the same one from the ALIAS_RESOLVER environment variable when it's
+	// started.
+	aliasResolver string
 	// where to listen for dnstap messages
 	dnstapAddress string
+	// DNS-over-TLS is optional, it's only enabled if there's a certificate
+	dotAddress  string
+	tlsCertFile string
+	tlsKeyFile  string
+	// per-IP query rate limiting, in case we're not running behind dnsdist
+	rateLimit     ratelimit.Config
+	rateLimitDrop bool
+	// response rate limiting, so we can't be used for amplification attacks
+	rrl ratelimit.RRLConfig
+	// where to serve pprof, /metrics and the /debug endpoints. They're not
+	// public, so this should only be reachable from inside our network.
+	adminAddress string
+	// whether we're behind a proxy (Fly's, in production) that tells us the
+	// client's IP in Fly-Client-IP and X-Forwarded-For. Otherwise anyone
+	// can set those headers, so they're ignored.
+	behindProxy bool
 }
 
 func readConfig() (*Config, error) {
@@ -54,6 +91,10 @@ func readConfig() (*Config, error) {
 	if userDBFilename == "" {
 		return nil, fmt.Errorf("USER_DB_FILENAME must be set")
 	}
+	historyDBFilename := os.Getenv("HISTORY_DB_FILENAME")
+	if historyDBFilename == "" {
+		return nil, fmt.Errorf("HISTORY_DB_FILENAME must be set")
+	}
 	hashKey := os.Getenv("HASH_KEY")
 	if hashKey == "" {
 		return nil, fmt.Errorf("HASH_KEY must be set")
@@ -62,14 +103,82 @@ func readConfig() (*Config, error) {
 	if blockKey == "" {
 		return nil, fmt.Errorf("BLOCK_KEY must be set")
 	}
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	recordsBackend := os.Getenv("RECORDS_BACKEND")
+	if recordsBackend == "" {
+		recordsBackend = "powerdns"
+	}
+	if recordsBackend != "powerdns" && recordsBackend != "local" {
+		return nil, fmt.Errorf("RECORDS_BACKEND must be \"powerdns\" or \"local\"")
+	}
+	adminAddress := os.Getenv("ADMIN_ADDRESS")
+	if adminAddress == "" {
+		adminAddress = "localhost:6060"
+	}
+	dotAddress := os.Getenv("DOT_ADDRESS")
+	if dotAddress == "" {
+		dotAddress = ":853"
+	}
+	// allow 50 QPS per IP by default
+	rateLimit := ratelimit.Config{QPS: 50, Burst: 100}
+	if qps := os.Getenv("RATE_LIMIT_QPS"); qps != "" {
+		parsed, err := strconv.ParseFloat(qps, 64)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_QPS must be a number: %s", err)
+		}
+		rateLimit.QPS = parsed
+		rateLimit.Burst = 2 * parsed
+	}
+	// limit by /24 or /56 instead of by individual IP
+	if os.Getenv("RATE_LIMIT_BY_PREFIX") == "true" {
+		rateLimit.IPv4PrefixLen = 24
+		rateLimit.IPv6PrefixLen = 56
+	}
+	rrl := ratelimit.RRLConfig{
+		ResponsesPerSecond: 10,
+		Slip:               2,
+		DryRun:             os.Getenv("RRL_DRY_RUN") == "true",
+	}
+	if rps := os.Getenv("RRL_RESPONSES_PER_SECOND"); rps != "" {
+		parsed, err := strconv.ParseFloat(rps, 64)
+		if err != nil {
+			return nil, fmt.Errorf("RRL_RESPONSES_PER_SECOND must be a number: %s", err)
+		}
+		rrl.ResponsesPerSecond = parsed
+	}
+	if slip := os.Getenv("RRL_SLIP"); slip != "" {
+		parsed, err := strconv.Atoi(slip)
+		if err != nil {
+			return nil, fmt.Errorf("RRL_SLIP must be a number: %s", err)
+		}
+		rrl.Slip = parsed
+	}
 	return &Config{
-		workdir:           workdir,
-		requestDBFilename: requestDBFilename,
-		userDBFilename:    userDBFilename,
-		hashKey:           hashKey,
-		blockKey:          blockKey,
-		powerdnsAddress:   "http://localhost:8081",
-		dnstapAddress:     "localhost:7777",
+		workdir:                workdir,
+		requestDBFilename:      requestDBFilename,
+		userDBFilename:         userDBFilename,
+		historyDBFilename:      historyDBFilename,
+		hashKey:                hashKey,
+		blockKey:               blockKey,
+		powerdnsAddress:        "http://localhost:8081",
+		upstreamAddress:        "localhost:5454",
+		recordsBackend:         recordsBackend,
+		localRecordsDBFilename: os.Getenv("LOCAL_RECORDS_DB_FILENAME"),
+		zoneFile:               os.Getenv("ZONE_FILE"),
+		aliasResolver:          os.Getenv("ALIAS_RESOLVER"),
+		dnstapAddress:          "localhost:7777",
+		dotAddress:             dotAddress,
+		tlsCertFile:            tlsCertFile,
+		tlsKeyFile:             tlsKeyFile,
+		rateLimit:              rateLimit,
+		rateLimitDrop:          os.Getenv("RATE_LIMIT_DROP") == "true",
+		rrl:                    rrl,
+		adminAddress:           adminAddress,
+		behindProxy:            os.Getenv("BEHIND_PROXY") == "true",
 	}, nil
 }
 
@@ -84,10 +193,6 @@ func main() {
 		log.Fatalf("error setting up OTel SDK - %e", err)
 	}
 	defer otelShutdown()
-	// start pprof
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
 
 	config, err := readConfig()
 	if err != nil {
@@ -99,6 +204,15 @@ func main() {
 		log.Fatalf(err.Error())
 	}
 	go handler.cleanup()
+	// these are only on the admin listener, along with pprof. The debug
+	// endpoints have IP addresses in them.
+	http.HandleFunc("/debug/ratelimit", handler.rateLimitStats)
+	http.HandleFunc("/debug/rrl", handler.rrlStats)
+	http.Handle("/metrics", promhttp.Handler())
+	fmt.Println("Listening for admin requests on", config.adminAddress)
+	go func() {
+		log.Println(http.ListenAndServe(config.adminAddress, nil))
+	}()
 
 	port := ":53"
 	if len(os.Args) > 1 {
@@ -118,6 +232,19 @@ func main() {
 			panic(fmt.Sprintf("Failed to set tcp listener %s\n", err.Error()))
 		}
 	}()
+	if config.tlsCertFile != "" {
+		tlsConfig, err := loadTLSConfig(config.tlsCertFile, config.tlsKeyFile)
+		if err != nil {
+			log.Fatalf("error loading TLS certificate: %s", err.Error())
+		}
+		fmt.Println("Listening for DNS-over-TLS on", config.dotAddress)
+		go func() {
+			srv := &dns.Server{Handler: handler, Addr: config.dotAddress, Net: "tcp-tls", TLSConfig: tlsConfig}
+			if err := srv.ListenAndServe(); err != nil {
+				panic(fmt.Sprintf("Failed to set tcp-tls listener %s\n", err.Error()))
+			}
+		}()
+	}
 
 	fmt.Println("Listening on :8080")
 	err = (&http.Server{Addr: ":8080", Handler: createRoutes(handler)}).ListenAndServe()
@@ -126,6 +253,14 @@ func main() {
 	}
 }
 
+func loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
 func createHandler(ctx context.Context, config *Config) (*handler, error) {
 	logger, err := streamer.Init(ctx, config.workdir, config.requestDBFilename, config.dnstapAddress)
 	if err != nil {
@@ -136,62 +271,205 @@ func createHandler(ctx context.Context, config *Config) (*handler, error) {
 		return nil, fmt.Errorf("error connecting to user database: %s", err.Error())
 	}
 	rs := records.Init(config.powerdnsAddress, "not-a-secret")
+	var local *records.LocalBackend
+	if config.recordsBackend == "local" {
+		local, err = records.NewLocalBackend(config.localRecordsDBFilename)
+		if err != nil {
+			return nil, fmt.Errorf("error loading local records: %s", err.Error())
+		}
+		if config.zoneFile != "" {
+			err = local.LoadZoneFile(config.zoneFile)
+			if err != nil {
+				return nil, fmt.Errorf("error loading zone file: %s", err.Error())
+			}
+		}
+		if config.aliasResolver != "" {
+			local.SetAliasResolver(config.aliasResolver)
+		}
+		rs = records.NewRecordService(local)
+	}
+	history, err := records.NewHistory(config.historyDBFilename)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to history database: %s", err.Error())
+	}
+	rs = rs.WithHistory(history)
 
 	handler := &handler{
 		rs:          rs,
+		local:       local,
+		history:     history,
 		logger:      logger,
 		userService: userService,
 		workdir:     config.workdir,
+		upstream:    config.upstreamAddress,
+		limiter:     ratelimit.New(config.rateLimit),
+		dropLimited: config.rateLimitDrop,
+		rrl:         ratelimit.NewRRL(config.rrl),
+		behindProxy: config.behindProxy,
+	}
+	if local != nil {
+		handler.rrl.SetWildcards(local.Wildcard)
+	} else {
+		handler.rrl.SetWildcards(rs.Wildcard)
 	}
 	return handler, nil
 }
 
 type handler struct {
-	logger      *streamer.Logger
-	rs          records.RecordService
+	logger *streamer.Logger
+	rs     records.RecordService
+	// if this is set, we answer DNS queries from it instead of proxying
+	local       *records.LocalBackend
+	history     *records.History
 	userService *users.UserService
 	workdir     string
+	upstream    string
+	limiter     *ratelimit.Limiter
+	// if this is false, rate limited queries get REFUSED
+	dropLimited bool
+	rrl         *ratelimit.RRL
+	// trust the client IP headers from our proxy, see Config.behindProxy
+	behindProxy bool
 }
 
+// returnError sends err as JSON, like {"code": "invalid_request", "message":
+// "..."}. See records.HTTPError for the format.
 func returnError(w http.ResponseWriter, r *http.Request, err error, status int) {
 	msg := fmt.Sprintf("Error [%d]: %s\n", status, err.Error())
 	logMsg(r, msg)
-	http.Error(w, err.Error(), status)
+	writeError(w, records.ToHTTPError(status, err))
 	ctx := r.Context()
 	span := trace.SpanFromContext(ctx)
 	span.RecordError(err)
 }
 
+func writeError(w http.ResponseWriter, err *records.HTTPError) {
+	jsonOutput, err2 := json.Marshal(err)
+	if err2 != nil {
+		http.Error(w, err.Error(), err.Code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Code)
+	w.Write(jsonOutput)
+}
+
 func logMsg(r *http.Request, msg string) {
-	ip := r.Header.Get("X-Forwarded-For")
-	ip = strings.Split(ip, ",")[0]
-	fmt.Printf("[%s] %s\n", ip, msg)
+	fmt.Printf("[%s] %s\n", clientIP(r), msg)
 }
 
-func (handle *handler) serveDNS(w dns.ResponseWriter, r *dns.Msg) error {
-	// Proxy it to localhost:5454
+// clientIP is the IP address of whoever made the request. If we're behind
+// a proxy, proxyMiddleware has already set RemoteAddr to the client's.
+func clientIP(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return host
+}
+
+// forwardedIP is the client's IP according to the proxy we're behind, or ""
+// if it didn't say. Fly's proxy sets Fly-Client-IP and adds the address it
+// got the request from to the end of X-Forwarded-For. The client can put
+// whatever it wants in the rest of X-Forwarded-For, so we don't use that.
+func forwardedIP(r *http.Request) string {
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("Fly-Client-IP"))); ip != nil {
+		return ip.String()
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// proxyMiddleware sets the request's RemoteAddr to the client's address from
+// the proxy's headers. Only use it if we're behind a proxy we trust.
+func proxyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := forwardedIP(r); ip != "" {
+			r = r.WithContext(r.Context())
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (handle *handler) proxy(r *dns.Msg) (*dns.Msg, streamer.RequestMeta, error) {
+	// Proxy it to powerdns
 	c := &dns.Client{
 		Net:         "udp",
 		DialTimeout: time.Second * 1,
 	}
-	response, _, err := c.Exchange(r, "localhost:5454")
+	response, rtt, err := c.Exchange(r, handle.upstream)
 
 	if err != nil {
-		return err
+		return nil, streamer.RequestMeta{}, err
+	}
+	metrics.ObserveUpstream(c.Net, rtt)
+	meta := streamer.RequestMeta{
+		RequestSize: r.Len(),
+		UpstreamRTT: rtt,
 	}
 	if response.Truncated {
 		// try TCP instead if the response was truncated
 		c.Net = "tcp"
-		response, _, err = c.Exchange(r, "localhost:5454")
+		response, rtt, err = c.Exchange(r, handle.upstream)
+		if err != nil {
+			return nil, streamer.RequestMeta{}, err
+		}
+		metrics.ObserveUpstream(c.Net, rtt)
+		meta.RetriedTCP = true
+		// count the time we spent on both tries
+		meta.UpstreamRTT += rtt
+	}
+	return response, meta, nil
+}
+
+// udpSize is the biggest response the client says it can handle over UDP
+func udpSize(r *dns.Msg) int {
+	if opt := r.IsEdns0(); opt != nil && opt.UDPSize() > dns.MinMsgSize {
+		return int(opt.UDPSize())
+	}
+	return dns.MinMsgSize
+}
+
+func (handle *handler) serveDNS(w dns.ResponseWriter, r *dns.Msg) error {
+	var response *dns.Msg
+	var meta streamer.RequestMeta
+	var err error
+	if handle.local != nil {
+		var alias *records.AliasResolution
+		response, alias = handle.local.AnswerQuery(r)
+		meta = streamer.RequestMeta{RequestSize: r.Len()}
+		if alias != nil {
+			meta.Alias = streamer.NewAliasLog(alias.Target, alias.Resolver, alias.Records, alias.Err)
+		}
+		// powerdns does this for us when we're proxying
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			response.Truncate(udpSize(r))
+		}
+	} else {
+		response, meta, err = handle.proxy(r)
 		if err != nil {
 			return err
 		}
 	}
+	// response rate limiting is only for UDP: over TCP the source address
+	// can't be spoofed, so nobody can use us to send traffic to someone else
+	if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		switch handle.rrl.Check(addr.IP, response) {
+		case ratelimit.Drop:
+			return nil
+		case ratelimit.Slip:
+			return w.WriteMsg(ratelimit.Truncated(r))
+		}
+	}
 	err = w.WriteMsg(response)
 	if err != nil {
 		return err
 	}
-	err = handle.logger.Log(response, w)
+	metrics.CountQuery(r, response.Rcode, streamer.GetTransport(w))
+	meta.ResponseSize = response.Len()
+	err = handle.logger.Log(r, response, w, meta)
 
 	if err != nil {
 		return err
@@ -199,7 +477,6 @@ func (handle *handler) serveDNS(w dns.ResponseWriter, r *dns.Msg) error {
 	return nil
 }
 
-// allow 50 QPS per IP
 func servFail(r *dns.Msg) *dns.Msg {
 	m := new(dns.Msg)
 	m.SetReply(r)
@@ -207,6 +484,30 @@ func servFail(r *dns.Msg) *dns.Msg {
 	return m
 }
 
+func refused(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	return m
+}
+
+func (handle *handler) rateLimitStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, handle.limiter.Stats())
+}
+
+func (handle *handler) rrlStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, handle.rrl.Stats())
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	jsonOutput, err := json.Marshal(v)
+	if err != nil {
+		returnError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonOutput)
+}
+
 func (handle *handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	ctx := context.Background()
 	ip, _, _ := net.SplitHostPort(w.RemoteAddr().String())
@@ -216,16 +517,34 @@ func (handle *handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		span.SetAttributes(attribute.String("dns.source", ip))
 	}
 
+	if !handle.limiter.Allow(net.ParseIP(ip)) {
+		// don't log these, the point is to protect the request log from floods
+		span.SetAttributes(attribute.Bool("dns.rate_limited", true))
+		if !handle.dropLimited {
+			err := w.WriteMsg(refused(r))
+			if err != nil {
+				span.RecordError(err)
+			} else {
+				metrics.CountQuery(r, dns.RcodeRefused, streamer.GetTransport(w))
+			}
+		}
+		span.End()
+		return
+	}
+
 	err := handle.serveDNS(w, r)
 
 	if err != nil {
 		// return a SERVFAIL
 		fmt.Println("error serving DNS", err)
 		span.RecordError(err)
+		metrics.DNSServfails.Inc()
 		err = w.WriteMsg(servFail(r))
 		if err != nil {
 			fmt.Println("error writing SERVFAIL", err)
 			span.RecordError(err)
+		} else {
+			metrics.CountQuery(r, dns.RcodeServerFailure, streamer.GetTransport(w))
 		}
 	}
 	span.End()
@@ -251,7 +570,12 @@ func (handle *handler) cleanup() {
 	defer span.End()
 	for {
 		fmt.Println("Deleting old records & requests...")
-		err := deleteOldRecords()
+		var err error
+		if handle.local != nil {
+			err = handle.local.DeleteOldZones(ctx, time.Now().Add(-14*24*time.Hour))
+		} else {
+			err = deleteOldRecords()
+		}
 		if err != nil {
 			span.RecordError(fmt.Errorf("error deleting old records: %s", err))
 			fmt.Println("error deleting old records:", err)
@@ -261,6 +585,11 @@ func (handle *handler) cleanup() {
 			span.RecordError(fmt.Errorf("error deleting old requests: %s", err))
 			fmt.Println("error deleting old requests:", err)
 		}
+		err = handle.history.DeleteOld(ctx, time.Now().Add(-14*24*time.Hour))
+		if err != nil {
+			span.RecordError(fmt.Errorf("error deleting old history: %s", err))
+			fmt.Println("error deleting old history:", err)
+		}
 		time.Sleep(time.Minute * 15)
 	}
 }
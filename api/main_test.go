@@ -5,10 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/jvns/mess-with-dns/metrics"
+	"github.com/jvns/mess-with-dns/parsing"
+	"github.com/jvns/mess-with-dns/ratelimit"
+	"github.com/jvns/mess-with-dns/records"
 	//"github.com/jvns/mess-with-dns/streamer"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -31,9 +37,11 @@ func createTestHandler(t *testing.T) *handler {
 		workdir:           "..",
 		requestDBFilename: ":memory:",
 		userDBFilename:    ":memory:",
+		historyDBFilename: ":memory:",
 		hashKey:           base64Hash,
 		blockKey:          base64Block,
 		powerdnsAddress:   "http://localhost:8082",
+		upstreamAddress:   "localhost:5555",
 		dnstapAddress:     "localhost:7111",
 	}
 
@@ -57,8 +65,43 @@ func sendDNSRequest(name string, qtype uint16) (*dns.Msg, error) {
 	return response, err
 }
 
+// startTestUpstream starts a fake DNS server for serveDNS to proxy to, so
+// that we don't need powerdns running to test the DNS code path. Names
+// starting with "truncated." get truncated responses over UDP.
+func startTestUpstream(t *testing.T) string {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		_, udp := w.RemoteAddr().(*net.UDPAddr)
+		if udp && strings.HasPrefix(r.Question[0].Name, "truncated.") {
+			m.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 1.2.3.4")
+			m.Answer = []dns.RR{rr}
+		}
+		w.WriteMsg(m)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	fatalIfErr(t, err)
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	fatalIfErr(t, err)
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler}
+	tcpServer := &dns.Server{Listener: ln, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	})
+	return pc.LocalAddr().String()
+}
+
 func createTestServer(t *testing.T) *httptest.Server {
-	handler := createTestHandler(t)
+	return createTestServerWithHandler(createTestHandler(t))
+}
+
+func createTestServerWithHandler(handler *handler) *httptest.Server {
 	return httptest.NewServer(createRoutes(handler))
 }
 
@@ -176,6 +219,298 @@ func login(t *testing.T, ts *httptest.Server) (*http.Client, *websocket.Conn, st
 	return client, ws, username
 }
 
+func TestRateLimit(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+	handler.limiter = ratelimit.New(ratelimit.Config{QPS: 1, Burst: 2})
+
+	query := new(dns.Msg)
+	query.SetQuestion("orange.messwithdns.com.", dns.TypeA)
+	rcodes := []int{}
+	for i := 0; i < 3; i++ {
+		w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+		handler.ServeDNS(w, query)
+		rcodes = append(rcodes, w.msg.Rcode)
+	}
+	assert.Equal(t, []int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeRefused}, rcodes)
+	assert.Equal(t, uint64(1), handler.limiter.Stats().Limited)
+
+	// dropping means we don't write a response at all
+	handler.dropLimited = true
+	w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.Nil(t, w.msg)
+
+	// rate limited requests don't get logged
+	logs, err := handler.logger.GetRequests(context.Background(), "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, 2, len(logs))
+}
+
+func TestRRL(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+	handler.rrl = ratelimit.NewRRL(ratelimit.RRLConfig{ResponsesPerSecond: 1, Slip: 2})
+
+	query := new(dns.Msg)
+	query.SetQuestion("orange.messwithdns.com.", dns.TypeA)
+	responses := []*dns.Msg{}
+	for i := 0; i < 3; i++ {
+		w := &dohResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}}
+		handler.ServeDNS(w, query)
+		responses = append(responses, w.msg)
+	}
+	// sent, dropped, slipped
+	assert.Equal(t, 1, len(responses[0].Answer))
+	assert.Nil(t, responses[1])
+	assert.True(t, responses[2].Truncated)
+	assert.Equal(t, 0, len(responses[2].Answer))
+
+	// TCP responses never get limited
+	w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.Equal(t, 1, len(w.msg.Answer))
+}
+
+func TestRequestMeta(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+
+	query := new(dns.Msg)
+	query.SetQuestion("truncated.orange.messwithdns.com.", dns.TypeA)
+	w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.Equal(t, 1, len(w.msg.Answer))
+
+	logs, err := handler.logger.GetRequests(context.Background(), "orange")
+	fatalIfErr(t, err)
+	log := logs[0]
+	assert.Equal(t, "doh", log.Request.Transport)
+	assert.Equal(t, query.Len(), log.Request.Size)
+	assert.Equal(t, w.msg.Len(), log.Response.Size)
+	assert.True(t, log.Response.RetriedTCP)
+	assert.Greater(t, log.Response.UpstreamRTT, 0.0)
+}
+
+func TestIfMatch(t *testing.T) {
+	local, err := records.NewLocalBackend("")
+	fatalIfErr(t, err)
+	rs := records.NewRecordService(local)
+	ctx := context.Background()
+	record := map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}
+	if err := rs.CreateRecord(ctx, "orange", record); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	getRecords("orange", rs, w, httptest.NewRequest("GET", "/records", nil))
+	etag := w.Header().Get("ETag")
+	id := records.PdnsID{Name: "www.orange.messwithdns.com.", Type: "A", Content: "1.2.3.4"}.String()
+	deleteWithETag := func(etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/records/"+id, nil)
+		r.Header.Set("If-Match", etag)
+		ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			deleteRecord("orange", id, rs, w, r)
+		})(w, r)
+		return w
+	}
+
+	// someone else changes the zone, so our ETag is out of date
+	record = map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "5.6.7.8"}
+	if err := rs.CreateRecord(ctx, "orange", record); err != nil {
+		t.Fatal(err)
+	}
+	w = deleteWithETag(etag)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	conflict := struct {
+		Code    string
+		Details struct {
+			Records []records.Record
+		}
+	}{}
+	fatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &conflict))
+	assert.Equal(t, records.CodeEditConflict, conflict.Code)
+	// SOA + 2 A records
+	assert.Equal(t, 3, len(conflict.Details.Records))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// a list works if any of the ETags match, and weak ETags are fine
+	w = deleteWithETag(etag + `, W/` + w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = deleteWithETag("banana")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCORSExposesETag(t *testing.T) {
+	handler := createTestHandler(t)
+	w := httptest.NewRecorder()
+	cors := handler.corsLoginMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cors.ServeHTTP(w, httptest.NewRequest("GET", "/records", nil))
+	assert.Equal(t, "ETag", w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestErrorFormat(t *testing.T) {
+	local, err := records.NewLocalBackend("")
+	fatalIfErr(t, err)
+	rs := records.NewRecordService(local)
+	create := func(body string) records.HTTPError {
+		w := httptest.NewRecorder()
+		createRecord("orange", rs, w, httptest.NewRequest("POST", "/records", strings.NewReader(body)))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		httpErr := records.HTTPError{Code: w.Code}
+		if w.Code != http.StatusOK {
+			fatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &httpErr))
+		}
+		return httpErr
+	}
+	assert.Equal(t, records.HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: parsing.CodeInvalidValue,
+		Message:   "Preference is not between 0-65535: lots",
+		Field:     "value_Preference",
+	}, create(`{"subdomain": "@", "type": "MX", "ttl": "60", "value_Preference": "lots", "value_Mx": "mail.example.com"}`))
+	assert.Equal(t, http.StatusOK, create(`{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}`).Code)
+	assert.Equal(t, records.CodeDuplicateRecord, create(`{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}`).ErrorCode)
+	assert.Equal(t, records.CodeCNAMEConflict, create(`{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": "example.com"}`).ErrorCode)
+	assert.Equal(t, records.CodeInvalidRequest, create(`not json`).ErrorCode)
+
+	w := httptest.NewRecorder()
+	importRecords("orange", rs, w, httptest.NewRequest("POST", "/records/import", strings.NewReader("ok A 1.2.3.4\nbad A banana\n")))
+	importErr := struct {
+		Code    string
+		Details struct {
+			Errors []parsing.LineError
+		}
+	}{}
+	fatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &importErr))
+	assert.Equal(t, parsing.CodeSyntaxError, importErr.Code)
+	assert.Equal(t, 2, importErr.Details.Errors[0].Line)
+}
+
+func TestSyncZoneRoundTrip(t *testing.T) {
+	local, err := records.NewLocalBackend("")
+	fatalIfErr(t, err)
+	rs := records.NewRecordService(local)
+	ctx := context.Background()
+	// long TXT records have "strings" in GET /records' output
+	for _, record := range []map[string]string{
+		{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+		{"subdomain": "@", "type": "TXT", "ttl": "60", "value_Txt": strings.Repeat("a", 300)},
+	} {
+		if err := rs.CreateRecord(ctx, "orange", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	getRecords("orange", rs, w, httptest.NewRequest("GET", "/records", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"strings"`)
+
+	// sending it straight back doesn't change anything
+	r := httptest.NewRequest("PUT", "/zone?dry_run=true", strings.NewReader(w.Body.String()))
+	w = httptest.NewRecorder()
+	syncZone("orange", rs, w, r)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	plan := records.ZonePlan{}
+	fatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &plan))
+	assert.Equal(t, 0, len(plan.Adds)+len(plan.Removes)+len(plan.Changes))
+}
+
+func TestHistory(t *testing.T) {
+	local, err := records.NewLocalBackend("")
+	fatalIfErr(t, err)
+	history, err := records.NewHistory(":memory:")
+	fatalIfErr(t, err)
+	rs := records.NewRecordService(local).WithHistory(history)
+	r := httptest.NewRequest("POST", "/records", strings.NewReader(`{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}`))
+	r = r.WithContext(records.WithSourceIP(r.Context(), clientIP(r)))
+	w := httptest.NewRecorder()
+	createRecord("orange", rs, w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	getHistory("orange", rs, w, httptest.NewRequest("GET", "/records/history", nil))
+	changes := []records.Change{}
+	fatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "192.0.2.1", changes[0].SourceIP)
+
+	revert := func(id string) int {
+		w := httptest.NewRecorder()
+		revertChange("orange", id, rs, w, httptest.NewRequest("POST", "/records/history/"+id+"/revert", nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, revert("banana"))
+	assert.Equal(t, http.StatusNotFound, revert("12345"))
+	assert.Equal(t, http.StatusOK, revert(fmt.Sprint(changes[0].ID)))
+	recs, httpErr := rs.GetRecords(context.Background(), "orange")
+	if httpErr != nil {
+		t.Fatal(httpErr)
+	}
+	// just the SOA
+	assert.Equal(t, 1, len(recs))
+}
+
+func TestMetrics(t *testing.T) {
+	handler := createTestHandler(t)
+	handler.upstream = startTestUpstream(t)
+
+	queries := metrics.DNSQueries.WithLabelValues("A", "NOERROR", "doh")
+	before := testutil.ToFloat64(queries)
+	query := new(dns.Msg)
+	query.SetQuestion("truncated.orange.messwithdns.com.", dns.TypeA)
+	w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.Equal(t, before+1, testutil.ToFloat64(queries))
+	// we asked over UDP first and then retried over TCP
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.UpstreamDuration))
+
+	// nothing's listening here, so we send a SERVFAIL
+	handler.upstream = "127.0.0.1:1"
+	servfails := testutil.ToFloat64(metrics.DNSServfails)
+	handler.ServeDNS(&dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, query)
+	assert.Equal(t, servfails+1, testutil.ToFloat64(metrics.DNSServfails))
+
+	// /presets doesn't need the network, unlike /health
+	presets := metrics.HTTPResponses.WithLabelValues("GET /presets", "200")
+	before = testutil.ToFloat64(presets)
+	createRoutes(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/presets", nil))
+	assert.Equal(t, before+1, testutil.ToFloat64(presets))
+}
+
+func TestLocalRecords(t *testing.T) {
+	handler := createTestHandler(t)
+	local, err := records.NewLocalBackend("")
+	fatalIfErr(t, err)
+	handler.local = local
+	handler.rs = records.NewRecordService(local)
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		record := map[string]string{"subdomain": "@", "type": "TXT", "ttl": "60", "value_Txt": fmt.Sprintf("this is TXT record number %d", i)}
+		if err := handler.rs.CreateRecord(ctx, "orange", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("orange.messwithdns.com.", dns.TypeTXT)
+	w := &dohResponseWriter{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.True(t, w.msg.Authoritative)
+	assert.Equal(t, 30, len(w.msg.Answer))
+
+	// the response doesn't fit in 512 bytes, so it gets truncated over UDP
+	w = &dohResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}}
+	handler.ServeDNS(w, query)
+	assert.True(t, w.msg.Truncated)
+	assert.LessOrEqual(t, w.msg.Len(), dns.MinMsgSize)
+
+	logs, err := handler.logger.GetRequests(ctx, "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, 2, len(logs))
+}
+
 type Record2 struct {
 	ID     string            `json:"id"`
 	Record map[string]string `json:"record"`
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// These are all served on /metrics on the admin listener, in Prometheus's
+// text format. They're in their own package so that both main and streamer
+// can update them.
+
+var DNSQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messwithdns_dns_queries_total",
+	Help: "DNS queries we answered, by query type, response code and transport",
+}, []string{"qtype", "rcode", "transport"})
+
+var DNSServfails = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "messwithdns_dns_servfails_total",
+	Help: "SERVFAILs we sent because something went wrong answering a query",
+})
+
+var UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "messwithdns_upstream_exchange_seconds",
+	Help:    "How long queries to the upstream DNS server took, by protocol",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"net"})
+
+var Streams = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "messwithdns_request_streams",
+	Help: "Websocket streams of DNS requests that are currently open",
+})
+
+var RequestInsertDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "messwithdns_request_insert_seconds",
+	Help:    "How long it took to save a DNS request to the requests database",
+	Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+})
+
+var HTTPResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messwithdns_http_responses_total",
+	Help: "HTTP responses from the API, by route and status code",
+}, []string{"route", "code"})
+
+// CountQuery counts a DNS response we sent. The query type comes from the
+// question, since the response might not have one (like if it's a FORMERR)
+func CountQuery(query *dns.Msg, rcode int, transport string) {
+	qtype := "none"
+	if len(query.Question) > 0 {
+		qtype = dns.Type(query.Question[0].Qtype).String()
+	}
+	DNSQueries.WithLabelValues(qtype, dns.RcodeToString[rcode], transport).Inc()
+}
+
+func ObserveUpstream(net string, rtt time.Duration) {
+	UpstreamDuration.WithLabelValues(net).Observe(rtt.Seconds())
+}
+
+// InstrumentRoutes counts the status codes mux sends for each of its routes.
+// The route is the pattern that matched (like "GET /records/{record_id}"),
+// so that record IDs and usernames don't end up in the labels.
+func InstrumentRoutes(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// httpsnoop keeps the http.Hijacker that websockets need
+		m := httpsnoop.CaptureMetrics(mux, w, r)
+		route := r.Pattern
+		if route == "" {
+			route = "none"
+		}
+		HTTPResponses.WithLabelValues(route, strconv.Itoa(m.Code)).Inc()
+	})
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountQuery(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeMX)
+	CountQuery(query, dns.RcodeNameError, "udp")
+	assert.Equal(t, 1.0, testutil.ToFloat64(DNSQueries.WithLabelValues("MX", "NXDOMAIN", "udp")))
+
+	// queries without a question still get counted
+	CountQuery(new(dns.Msg), dns.RcodeFormatError, "tcp")
+	assert.Equal(t, 1.0, testutil.ToFloat64(DNSQueries.WithLabelValues("none", "FORMERR", "tcp")))
+}
+
+func TestInstrumentRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /records/{record_id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusNotFound)
+	})
+	handler := InstrumentRoutes(mux)
+	for _, path := range []string{"/records/1", "/records/2", "/banana"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	// the route is the pattern, not the path
+	assert.Equal(t, 2.0, testutil.ToFloat64(HTTPResponses.WithLabelValues("GET /records/{record_id}", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(HTTPResponses.WithLabelValues("none", "404")))
+}
@@ -0,0 +1,140 @@
+package parsing
+
+import "fmt"
+
+// Error codes for problems with a record request. They're part of the API
+// (the frontend and people's scripts check them instead of the message), so
+// don't change them.
+const (
+	CodeMissingValue    = "missing_value"
+	CodeInvalidValue    = "invalid_value"
+	CodeInvalidName     = "invalid_name"
+	CodeInvalidTTL      = "invalid_ttl"
+	CodeUnsupportedType = "unsupported_type"
+	CodeUnknownField    = "unknown_field"
+	// zone files that miekg/dns can't parse
+	CodeSyntaxError = "syntax_error"
+)
+
+// FieldError is an error about one field of a record request. Field is the
+// key in the request, like "ttl" or "value_Preference".
+type FieldError interface {
+	error
+	Field() string
+	Code() string
+}
+
+type ValueMissingError struct {
+	Name string
+}
+
+func (e *ValueMissingError) Error() string {
+	return fmt.Sprintf("Value missing from map: %s", e.Name)
+}
+
+func (e *ValueMissingError) Field() string { return "value_" + e.Name }
+func (e *ValueMissingError) Code() string  { return CodeMissingValue }
+
+type Uint32Error struct {
+	Name  string
+	Value string
+}
+
+func (e *Uint32Error) Error() string {
+	return fmt.Sprintf("%s is not between 0-4294967295: %s", e.Name, e.Value)
+}
+
+func (e *Uint32Error) Field() string { return "value_" + e.Name }
+func (e *Uint32Error) Code() string  { return CodeInvalidValue }
+
+type UInt16Error struct {
+	Name  string
+	Value string
+}
+
+func (e *UInt16Error) Error() string {
+	return fmt.Sprintf("%s is not between 0-65535: %s", e.Name, e.Value)
+}
+
+func (e *UInt16Error) Field() string { return "value_" + e.Name }
+func (e *UInt16Error) Code() string  { return CodeInvalidValue }
+
+type UInt8Error struct {
+	Name  string
+	Value string
+}
+
+func (e *UInt8Error) Error() string {
+	return fmt.Sprintf("%s is not between 0-255: %s", e.Name, e.Value)
+}
+
+func (e *UInt8Error) Field() string { return "value_" + e.Name }
+func (e *UInt8Error) Code() string  { return CodeInvalidValue }
+
+// InvalidValueError is for values that are there but don't make sense, like
+// an IP address that isn't one
+type InvalidValueError struct {
+	Name    string
+	Message string
+}
+
+func (e *InvalidValueError) Error() string { return e.Message }
+func (e *InvalidValueError) Field() string { return "value_" + e.Name }
+func (e *InvalidValueError) Code() string  { return CodeInvalidValue }
+
+// RequiredError is for the fields every record needs: subdomain, type and ttl
+type RequiredError struct {
+	Name string
+}
+
+func (e *RequiredError) Error() string { return fmt.Sprintf("%s is required", e.Name) }
+func (e *RequiredError) Field() string { return e.Name }
+func (e *RequiredError) Code() string  { return CodeMissingValue }
+
+type UnknownFieldError struct {
+	Name string
+}
+
+func (e *UnknownFieldError) Error() string { return fmt.Sprintf("invalid key: %s", e.Name) }
+func (e *UnknownFieldError) Field() string { return e.Name }
+func (e *UnknownFieldError) Code() string  { return CodeUnknownField }
+
+type TTLError struct {
+	Value string
+}
+
+func (e *TTLError) Error() string {
+	return fmt.Sprintf("Error: TTL must be a number from 1 to 2147483647, got \"%s\"", e.Value)
+}
+
+func (e *TTLError) Field() string { return "ttl" }
+func (e *TTLError) Code() string  { return CodeInvalidTTL }
+
+// ClassError is for zone file records that aren't in the IN class
+type ClassError struct {
+	Class string
+}
+
+func (e *ClassError) Error() string {
+	return fmt.Sprintf("only IN records are supported, got %s", e.Class)
+}
+
+func (e *ClassError) Field() string { return "class" }
+func (e *ClassError) Code() string  { return CodeInvalidValue }
+
+// NameError is for a subdomain that isn't a valid DNS name
+type NameError struct {
+	Message string
+}
+
+func (e *NameError) Error() string { return e.Message }
+func (e *NameError) Field() string { return "subdomain" }
+func (e *NameError) Code() string  { return CodeInvalidName }
+
+type TypeError struct {
+	Type string
+}
+
+func (e *TypeError) Error() string { return fmt.Sprintf("Unsupported record type: %s", e.Type) }
+func (e *TypeError) Field() string { return "type" }
+func (e *TypeError) Code() string  { return CodeUnsupportedType }
@@ -25,10 +25,12 @@ type RecordResponse struct {
 	Content    string            `json:"content"`
 	DomainName string            `json:"domain_name"`
 	Values     map[string]string `json:"values"`
+	// for TXT records, the character-strings the value got split into
+	Strings []string `json:"strings,omitempty"`
 }
 
 func (r *RecordResponse) MarshalJSON() ([]byte, error) {
-	m := map[string]string{
+	m := map[string]any{
 		"subdomain":   r.Subdomain,
 		"type":        r.Type,
 		"ttl":         r.TTL,
@@ -38,6 +40,9 @@ func (r *RecordResponse) MarshalJSON() ([]byte, error) {
 	for k, v := range r.Values {
 		m["value_"+k] = v
 	}
+	if r.Strings != nil {
+		m["strings"] = r.Strings
+	}
 	return json.Marshal(m)
 }
 
@@ -57,6 +62,12 @@ func RRsetToRecordResponse(rrset *powerdns.RRset) ([]RecordResponse, error) {
 		}
 		resp.Values = values
 		resp.Content = *record.Content
+		if *rrset.Type == powerdns.RRTypeTXT {
+			resp.Strings, err = TXTStrings(*record.Content)
+			if err != nil {
+				return nil, err
+			}
+		}
 		responses = append(responses, resp)
 	}
 	return responses, nil
@@ -94,22 +105,22 @@ func toRecordRequest(jsRecord map[string]string) (*RecordRequest, error) {
 	}
 	subdomain, ok := jsRecord["subdomain"]
 	if !ok {
-		return nil, fmt.Errorf("subdomain is required")
+		return nil, &RequiredError{Name: "subdomain"}
 	}
 	typ, ok := jsRecord["type"]
 	if !ok {
-		return nil, fmt.Errorf("type is required")
+		return nil, &RequiredError{Name: "type"}
 	}
 	ttl, ok := jsRecord["ttl"]
 	if !ok {
-		return nil, fmt.Errorf("ttl is required")
+		return nil, &RequiredError{Name: "ttl"}
 	}
 	values := map[string]string{}
 	for k, v := range jsRecord {
 		if k != "subdomain" && k != "type" && k != "ttl" {
 			// remove the "value_" prefix
 			if !strings.HasPrefix(k, "value_") {
-				return nil, fmt.Errorf("invalid key: %s", k)
+				return nil, &UnknownFieldError{Name: k}
 			}
 			values[strings.TrimPrefix(k, "value_")] = v
 		}
@@ -118,7 +129,7 @@ func toRecordRequest(jsRecord map[string]string) (*RecordRequest, error) {
 	// parse the TTL as a uint32
 	ttlInt, err := strconv.ParseUint(ttl, 10, 32)
 	if err != nil {
-		return nil, fmt.Errorf("Error: TTL must be a number from 1 to 2147483647, got \"%s\"", ttl)
+		return nil, &TTLError{Value: ttl}
 	}
 
 	return &RecordRequest{
@@ -132,14 +143,18 @@ func toRecordRequest(jsRecord map[string]string) (*RecordRequest, error) {
 func parseRecordRequest(jsRecord *RecordRequest, username string) (*powerdns.RRset, error) {
 	name, err := idna.ToASCII(fullName(jsRecord.Subdomain, username))
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert name to punycode: %s", err)
+		return nil, &NameError{Message: fmt.Sprintf("failed to convert name to punycode: %s", err)}
 	}
 	name = strings.ToLower(name)
 
 	// check if the dns name is valid
 
 	if _, ok := dns.IsDomainName(name); !ok {
-		return nil, fmt.Errorf("invalid domain name: %s", name)
+		return nil, &NameError{Message: fmt.Sprintf("invalid domain name: %s", name)}
+	}
+
+	if jsRecord.Typ == "ALIAS" && jsRecord.Subdomain != "@" {
+		return nil, &NameError{Message: "ALIAS records can only be at @, use a CNAME for subdomains"}
 	}
 
 	typ := powerdns.RRType(jsRecord.Typ)
@@ -165,6 +180,10 @@ func getType(typ string) (RR, error) {
 		return &CAA{}, nil
 	case "CNAME":
 		return &CNAME{}, nil
+	case "DNAME":
+		return &DNAME{}, nil
+	case "ALIAS":
+		return &ALIAS{}, nil
 	case "MX":
 		return &MX{}, nil
 	case "NS":
@@ -182,8 +201,28 @@ func getType(typ string) (RR, error) {
 	case "HTTPS":
 		// HTTPS and SVCB work the same way
 		return &SVCB{}, nil
-	}
-	return nil, fmt.Errorf("Unsupported record type: %s", typ)
+	case "TLSA":
+		return &TLSA{}, nil
+	case "SSHFP":
+		return &SSHFP{}, nil
+	case "OPENPGPKEY":
+		return &OPENPGPKEY{}, nil
+	case "NAPTR":
+		return &NAPTR{}, nil
+	case "URI":
+		return &URI{}, nil
+	case "LOC":
+		return &LOC{}, nil
+	case "HINFO":
+		return &HINFO{}, nil
+	case "RP":
+		return &RP{}, nil
+	case "DNSKEY":
+		return &DNSKEY{}, nil
+	case "DS":
+		return &DS{}, nil
+	}
+	return nil, &TypeError{Type: typ}
 }
 
 func parseContent(values map[string]string, typ string) (string, error) {
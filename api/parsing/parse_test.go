@@ -1,7 +1,8 @@
 package parsing
 
 import (
-	//"encoding/json"
+	"encoding/json"
+	"errors"
 	//"github.com/miekg/dns"
 	//"net"
 	"testing"
@@ -33,6 +34,28 @@ func TestParseTxt(t *testing.T) {
 	assert.Equal(t, *x.Records[0].Content, "\"hello world\"")
 }
 
+func TestRecordResponseStrings(t *testing.T) {
+	name := "test.messwithdns.com."
+	typ := powerdns.RRTypeTXT
+	ttl := uint32(60)
+	content := "\"v=spf1 \" \"-all\""
+	responses, err := RRsetToRecordResponse(&powerdns.RRset{Name: &name, Type: &typ, TTL: &ttl, Records: []powerdns.Record{{Content: &content}}})
+	fatalIfErr(t, err)
+	assert.Equal(t, []string{"v=spf1 ", "-all"}, responses[0].Strings)
+	data, err := json.Marshal(&responses[0])
+	fatalIfErr(t, err)
+	assert.JSONEq(t, `{"subdomain": "@", "type": "TXT", "ttl": "60", "content": "\"v=spf1 \" \"-all\"", "domain_name": "test.messwithdns.com.", "value_Txt": "v=spf1 -all", "strings": ["v=spf1 ", "-all"]}`, string(data))
+
+	// only TXT records have strings
+	typ = powerdns.RRTypeA
+	content = "1.2.3.4"
+	responses, err = RRsetToRecordResponse(&powerdns.RRset{Name: &name, Type: &typ, TTL: &ttl, Records: []powerdns.Record{{Content: &content}}})
+	fatalIfErr(t, err)
+	data, err = json.Marshal(&responses[0])
+	fatalIfErr(t, err)
+	assert.NotContains(t, string(data), "strings")
+}
+
 type TestCase struct {
 	Record  map[string]string
 	Content string
@@ -105,6 +128,8 @@ func TestParseError(t *testing.T) {
 	testCases := []map[string]string{
 		{"subdomain": "@", "type": "A", "ttl": "3600", "value_A": "1.2.3.5555"},
 		{"subdomain": "@", "type": "A", "ttl": "3600", "value_A": "banana"},
+		// these get created automatically
+		{"subdomain": "@", "type": "DNSKEY", "ttl": "3600", "value_Flags": "257", "value_Protocol": "3", "value_Algorithm": "13", "value_PublicKey": "abcd"},
 	}
 
 	for _, testCase := range testCases {
@@ -114,3 +139,31 @@ func TestParseError(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFieldError(t *testing.T) {
+	testCases := []struct {
+		Record map[string]string
+		Field  string
+		Code   string
+	}{
+		{map[string]string{"subdomain": "@", "type": "MX", "ttl": "3600", "value_Mx": "mail.example.com"}, "value_Preference", CodeMissingValue},
+		{map[string]string{"subdomain": "@", "type": "MX", "ttl": "3600", "value_Preference": "99999", "value_Mx": "mail.example.com"}, "value_Preference", CodeInvalidValue},
+		{map[string]string{"subdomain": "@", "type": "A", "ttl": "3600", "value_A": "banana"}, "value_A", CodeInvalidValue},
+		{map[string]string{"subdomain": "@", "type": "CNAME", "ttl": "3600", "value_Target": "a..b"}, "value_Target", CodeInvalidValue},
+		{map[string]string{"type": "A", "ttl": "3600", "value_A": "1.2.3.4"}, "subdomain", CodeMissingValue},
+		{map[string]string{"subdomain": "@", "type": "A", "ttl": "soon", "value_A": "1.2.3.4"}, "ttl", CodeInvalidTTL},
+		{map[string]string{"subdomain": "a..b", "type": "A", "ttl": "3600", "value_A": "1.2.3.4"}, "subdomain", CodeInvalidName},
+		{map[string]string{"subdomain": "@", "type": "BANANA", "ttl": "3600"}, "type", CodeUnsupportedType},
+		{map[string]string{"subdomain": "www", "type": "ALIAS", "ttl": "3600", "value_Target": "example.com"}, "subdomain", CodeInvalidName},
+		{map[string]string{"subdomain": "@", "type": "A", "ttl": "3600", "A": "1.2.3.4"}, "A", CodeUnknownField},
+	}
+	for _, testCase := range testCases {
+		_, err := ParseRecordRequest(testCase.Record, "test")
+		var fieldErr FieldError
+		if !errors.As(err, &fieldErr) {
+			t.Fatalf("expected a FieldError for %v, got %v", testCase.Record, err)
+		}
+		assert.Equal(t, testCase.Field, fieldErr.Field())
+		assert.Equal(t, testCase.Code, fieldErr.Code())
+	}
+}
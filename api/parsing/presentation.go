@@ -0,0 +1,95 @@
+package parsing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// PowerDNS stores record content in presentation format (the format records
+// have in a zone file), so we use miekg/dns to parse and print it instead of
+// splitting strings ourselves. That way anything PowerDNS stores can be
+// displayed and edited, and what we send it is always valid.
+
+// newRR parses a record's content
+func newRR(typ string, content string) (dns.RR, error) {
+	if strings.ContainsAny(content, "\n\r") {
+		return nil, fmt.Errorf("invalid %s record: %q", typ, content)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf(". 3600 IN %s %s", typ, content))
+	if err != nil || rr == nil {
+		return nil, fmt.Errorf("invalid %s record: %s", typ, content)
+	}
+	return rr, nil
+}
+
+// rdata is rr's content, without the name, TTL, class and type
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// normalize checks that content is valid, and formats it the way
+// miekg/dns does
+func normalize(typ string, content string) (string, error) {
+	rr, err := newRR(typ, content)
+	if err != nil {
+		return "", err
+	}
+	return rdata(rr), nil
+}
+
+// quote makes s into a quoted character-string, escaping quotes,
+// backslashes and anything that isn't printable ASCII
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unescape undoes the escaping in character-strings from miekg/dns, which
+// keeps the escapes from the presentation format (like \" and \195)
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			b.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i+1])
+		i++
+	}
+	return b.String()
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
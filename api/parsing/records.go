@@ -1,48 +1,18 @@
 package parsing
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/miekg/dns"
+	"math"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-type ValueMissingError struct {
-	Name string
-}
-
-func (e *ValueMissingError) Error() string {
-	return fmt.Sprintf("Value missing from map: %s", e.Name)
-}
-
-type Uint32Error struct {
-	Name  string
-	Value string
-}
-
-func (e *Uint32Error) Error() string {
-	return fmt.Sprintf("%s is not between 0-4294967295: %s", e.Name, e.Value)
-}
-
-type UInt16Error struct {
-	Name  string
-	Value string
-}
-
-func (e *UInt16Error) Error() string {
-	return fmt.Sprintf("%s is not between 0-65535: %s", e.Name, e.Value)
-}
-
-type UInt8Error struct {
-	Name  string
-	Value string
-}
-
-func (e *UInt8Error) Error() string {
-	return fmt.Sprintf("%s is not between 0-255: %s", e.Name, e.Value)
-}
-
 func toUint8(value string) (uint8, error) {
 	v, err := strconv.ParseUint(value, 10, 8)
 	if err != nil {
@@ -74,7 +44,7 @@ func getIPv4(m map[string]string, key string) (string, error) {
 	}
 	parseIP := net.ParseIP(addr)
 	if parseIP == nil || parseIP.To4() == nil {
-		return "", fmt.Errorf("Invalid IPv4 address: %s", addr)
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("Invalid IPv4 address: %s", addr)}
 	}
 	return addr, nil
 }
@@ -85,8 +55,10 @@ func getIPv6(m map[string]string, key string) (string, error) {
 		return "", &ValueMissingError{Name: key}
 	}
 	parseIP := net.ParseIP(addr)
-	if parseIP == nil || parseIP.To16() == nil {
-		return "", fmt.Errorf("Invalid IPv6 address: %s", addr)
+	// IPv4 addresses are valid IPv6 addresses as far as net.ParseIP is
+	// concerned, but they don't belong in AAAA records
+	if parseIP == nil || parseIP.To4() != nil {
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("Invalid IPv6 address: %s", addr)}
 	}
 	return addr, nil
 }
@@ -127,13 +99,61 @@ func getUint32(m map[string]string, key string) (uint32, error) {
 	return uint32(v), nil
 }
 
+// getUint8Range is getUint8 for values that have to be in a range, like
+// TLSA's usage field (0-3)
+func getUint8Range(m map[string]string, key string, min uint8, max uint8) (uint8, error) {
+	v, err := getUint8(m, key)
+	if err != nil {
+		return 0, err
+	}
+	if v < min || v > max {
+		return 0, &InvalidValueError{Name: key, Message: fmt.Sprintf("%s must be between %d and %d: %d", key, min, max, v)}
+	}
+	return v, nil
+}
+
+// getHex gets a hex value like a certificate digest. People copy these from
+// all kinds of places, so spaces and colons are ignored. If length isn't 0,
+// that's how many bytes it has to be.
+func getHex(m map[string]string, key string, length int) (string, error) {
+	val, ok := m[key]
+	if !ok {
+		return "", &ValueMissingError{Name: key}
+	}
+	val = strings.ToLower(strings.Join(strings.FieldsFunc(val, func(r rune) bool {
+		return r == ':' || r == ' ' || r == '\t'
+	}), ""))
+	b, err := hex.DecodeString(val)
+	if err != nil || len(b) == 0 {
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("%s isn't valid hex: %s", key, m[key])}
+	}
+	if length != 0 && len(b) != length {
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("%s should be %d hex digits long, but it's %d", key, length*2, len(val))}
+	}
+	return val, nil
+}
+
+func getBase64(m map[string]string, key string) (string, error) {
+	val, ok := m[key]
+	if !ok {
+		return "", &ValueMissingError{Name: key}
+	}
+	// keys are often split over several lines
+	val = strings.Join(strings.Fields(val), "")
+	b, err := base64.StdEncoding.DecodeString(val)
+	if err != nil || len(b) == 0 {
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("%s isn't valid base64", key)}
+	}
+	return val, nil
+}
+
 func getFqdn(m map[string]string, key string) (string, error) {
 	fqdn, ok := m[key]
 	if !ok {
 		return "", &ValueMissingError{Name: key}
 	}
 	if _, ok := dns.IsDomainName(fqdn); !ok {
-		return "", fmt.Errorf("invalid domain name: %s", fqdn)
+		return "", &InvalidValueError{Name: key, Message: fmt.Sprintf("invalid domain name: %s", fqdn)}
 	}
 	// check if it is a valid fqdn
 	return dns.Fqdn(fqdn), nil
@@ -144,11 +164,15 @@ func (a *A) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return addr, nil
+	return normalize("A", addr)
 }
 
 func (a *A) FromPDNS(s string) (map[string]string, error) {
-	return map[string]string{"A": s}, nil
+	rr, err := newRR("A", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"A": rr.(*dns.A).A.String()}, nil
 }
 
 type AAAA struct{}
@@ -158,12 +182,15 @@ func (a *AAAA) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	return addr, nil
+	return normalize("AAAA", addr)
 }
 
 func (a *AAAA) FromPDNS(s string) (map[string]string, error) {
-	return map[string]string{"AAAA": s}, nil
+	rr, err := newRR("AAAA", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"AAAA": rr.(*dns.AAAA).AAAA.String()}, nil
 }
 
 type CNAME struct{}
@@ -173,11 +200,61 @@ func (c *CNAME) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return cname, nil
+	return normalize("CNAME", cname)
 }
 
 func (c *CNAME) FromPDNS(s string) (map[string]string, error) {
-	return map[string]string{"Target": s}, nil
+	rr, err := newRR("CNAME", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Target": rr.(*dns.CNAME).Target}, nil
+}
+
+// DNAME records redirect a whole subtree: everything under the name gets
+// rewritten to be under the target instead (RFC 6672)
+
+type DNAME struct{}
+
+func (r *DNAME) ToPDNS(m map[string]string) (string, error) {
+	target, err := getFqdn(m, "Target")
+	if err != nil {
+		return "", err
+	}
+	return normalize("DNAME", target)
+}
+
+func (r *DNAME) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("DNAME", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Target": rr.(*dns.DNAME).Target}, nil
+}
+
+// ALIAS isn't a real record type: it's like a CNAME, but the server looks up
+// the target's A/AAAA records when it gets a query and answers with those.
+// Unlike a CNAME it can go at the top of a zone next to other records, so
+// we only allow it at @.
+
+type ALIAS struct{}
+
+func (r *ALIAS) ToPDNS(m map[string]string) (string, error) {
+	target, err := getFqdn(m, "Target")
+	if err != nil {
+		return "", err
+	}
+	// miekg/dns doesn't know about ALIAS, but the content is the same as
+	// a CNAME's
+	return normalize("CNAME", target)
+}
+
+func (r *ALIAS) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("CNAME", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Target": rr.(*dns.CNAME).Target}, nil
 }
 
 // mx
@@ -193,16 +270,16 @@ func (r *MX) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%d %s", pref, mx), nil
+	return normalize("MX", fmt.Sprintf("%d %s", pref, mx))
 }
 
 func (r *MX) FromPDNS(s string) (map[string]string, error) {
-	// split string on space
-	parts := strings.Split(s, " ")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid MX record: %s", s)
+	rr, err := newRR("MX", s)
+	if err != nil {
+		return nil, err
 	}
-	return map[string]string{"Preference": parts[0], "Mx": parts[1]}, nil
+	mx := rr.(*dns.MX)
+	return map[string]string{"Preference": strconv.Itoa(int(mx.Preference)), "Mx": mx.Mx}, nil
 }
 
 type NS struct{}
@@ -212,26 +289,82 @@ func (r *NS) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return ns, nil
+	return normalize("NS", ns)
 }
 
 func (r *NS) FromPDNS(s string) (map[string]string, error) {
-	return map[string]string{"Ns": s}, nil
+	rr, err := newRR("NS", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Ns": rr.(*dns.NS).Ns}, nil
 }
 
 type TXT struct{}
 
+// a character-string in a TXT record can only be 255 bytes long, so longer
+// values get split into several strings. Everything that reads TXT records
+// (like DKIM) joins them back together.
+const maxTXTString = 255
+
+// the whole record has to fit in 65535 bytes, which is 256 strings once
+// you count a length byte for each one
+const maxTXTLength = 65535 - 256
+
 func (r *TXT) ToPDNS(m map[string]string) (string, error) {
 	txt, ok := m["Txt"]
 	if !ok {
 		return "", &ValueMissingError{Name: "Txt"}
 	}
-	return fmt.Sprintf("\"%s\"", txt), nil
+	if len(txt) > maxTXTLength {
+		return "", &InvalidValueError{Name: "Txt", Message: fmt.Sprintf("TXT records can be at most %d bytes, this one is %d", maxTXTLength, len(txt))}
+	}
+	strs := []string{}
+	for _, str := range splitTXT(txt) {
+		strs = append(strs, quote(str))
+	}
+	return normalize("TXT", strings.Join(strs, " "))
+}
+
+// splitTXT splits a TXT value into character-strings. It tries not to split
+// a UTF-8 character in half, so that each string makes sense on its own.
+func splitTXT(txt string) []string {
+	strs := []string{}
+	for len(txt) > maxTXTString {
+		n := maxTXTString
+		for n > maxTXTString-utf8.UTFMax && !utf8.RuneStart(txt[n]) {
+			n--
+		}
+		if !utf8.RuneStart(txt[n]) {
+			// it isn't UTF-8
+			n = maxTXTString
+		}
+		strs = append(strs, txt[:n])
+		txt = txt[n:]
+	}
+	return append(strs, txt)
 }
 
 func (r *TXT) FromPDNS(s string) (map[string]string, error) {
-	s = strings.Trim(s, "\"")
-	return map[string]string{"Txt": s}, nil
+	strs, err := TXTStrings(s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Txt": strings.Join(strs, "")}, nil
+}
+
+// TXTStrings returns the character-strings in a TXT record, unescaped, so
+// that we can show people how their record got split up
+func TXTStrings(content string) ([]string, error) {
+	rr, err := newRR("TXT", content)
+	if err != nil {
+		return nil, err
+	}
+	strs := []string{}
+	for _, str := range rr.(*dns.TXT).Txt {
+		strs = append(strs, unescape(str))
+	}
+	return strs, nil
 }
 
 // ptr
@@ -243,11 +376,15 @@ func (r *PTR) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return ptr, nil
+	return normalize("PTR", ptr)
 }
 
 func (r *PTR) FromPDNS(s string) (map[string]string, error) {
-	return map[string]string{"Ptr": s}, nil
+	rr, err := newRR("PTR", s)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"Ptr": rr.(*dns.PTR).Ptr}, nil
 }
 
 type SRV struct{}
@@ -269,15 +406,21 @@ func (r *SRV) ToPDNS(m map[string]string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%d %d %d %s", priority, weight, port, target), nil
+	return normalize("SRV", fmt.Sprintf("%d %d %d %s", priority, weight, port, target))
 }
 
 func (r *SRV) FromPDNS(s string) (map[string]string, error) {
-	parts := strings.Split(s, " ")
-	if len(parts) != 4 {
-		return nil, fmt.Errorf("invalid SRV record: %s", s)
+	rr, err := newRR("SRV", s)
+	if err != nil {
+		return nil, err
 	}
-	return map[string]string{"Priority": parts[0], "Weight": parts[1], "Port": parts[2], "Target": parts[3]}, nil
+	srv := rr.(*dns.SRV)
+	return map[string]string{
+		"Priority": strconv.Itoa(int(srv.Priority)),
+		"Weight":   strconv.Itoa(int(srv.Weight)),
+		"Port":     strconv.Itoa(int(srv.Port)),
+		"Target":   srv.Target,
+	}, nil
 }
 
 type CAA struct{}
@@ -291,19 +434,24 @@ func (r *CAA) ToPDNS(m map[string]string) (string, error) {
 	if !ok {
 		return "", &ValueMissingError{Name: "Tag"}
 	}
+	// tags are letters and numbers (RFC 8659 section 4.1)
+	if tag == "" || strings.Trim(tag, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		return "", &InvalidValueError{Name: "Tag", Message: fmt.Sprintf("CAA tags can only have letters and numbers: %s", tag)}
+	}
 	value, ok := m["Value"]
 	if !ok {
 		return "", &ValueMissingError{Name: "Value"}
 	}
-	return fmt.Sprintf("%d %s \"%s\"", flag, tag, value), nil
+	return normalize("CAA", fmt.Sprintf("%d %s %s", flag, tag, quote(value)))
 }
 
 func (r *CAA) FromPDNS(s string) (map[string]string, error) {
-	parts := strings.Split(s, " ")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid CAA record: %s", s)
+	rr, err := newRR("CAA", s)
+	if err != nil {
+		return nil, err
 	}
-	return map[string]string{"Flag": parts[0], "Tag": parts[1], "Value": parts[2]}, nil
+	caa := rr.(*dns.CAA)
+	return map[string]string{"Flag": strconv.Itoa(int(caa.Flag)), "Tag": caa.Tag, "Value": unescape(caa.Value)}, nil
 }
 
 type SOA struct{}
@@ -339,45 +487,419 @@ func (r *SOA) ToPDNS(m map[string]string) (string, error) {
 	}
 	// replace the first "@" with a "." in the rname
 	rname = strings.Replace(rname, "@", ".", 1)
-	return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname, serial, refresh, retry, expire, minimum), nil
+	return normalize("SOA", fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname, serial, refresh, retry, expire, minimum))
 }
 
 func (r *SOA) FromPDNS(s string) (map[string]string, error) {
-	parts := strings.Split(s, " ")
-	if len(parts) != 7 {
-		return nil, fmt.Errorf("invalid SOA record: %s", s)
+	rr, err := newRR("SOA", s)
+	if err != nil {
+		return nil, err
+	}
+	soa := rr.(*dns.SOA)
+	return map[string]string{
+		"Mname": soa.Ns,
+		// replace the first "." with a "@" in the rname
+		"Rname":   strings.Replace(soa.Mbox, ".", "@", 1),
+		"Serial":  strconv.FormatUint(uint64(soa.Serial), 10),
+		"Refresh": strconv.FormatUint(uint64(soa.Refresh), 10),
+		"Retry":   strconv.FormatUint(uint64(soa.Retry), 10),
+		"Expire":  strconv.FormatUint(uint64(soa.Expire), 10),
+		"Minimum": strconv.FormatUint(uint64(soa.Minttl), 10),
+	}, nil
+}
+
+// TLSA records are for DANE: they say which TLS certificate a server
+// should have (RFC 6698)
+
+type TLSA struct{}
+
+// digest lengths for TLSA's matching types: 0 is the whole certificate or
+// key, 1 is SHA-256 and 2 is SHA-512
+var tlsaDigestLengths = map[uint8]int{0: 0, 1: 32, 2: 64}
+
+func (r *TLSA) ToPDNS(m map[string]string) (string, error) {
+	usage, err := getUint8Range(m, "Usage", 0, 3)
+	if err != nil {
+		return "", err
+	}
+	selector, err := getUint8Range(m, "Selector", 0, 1)
+	if err != nil {
+		return "", err
+	}
+	matchingType, err := getUint8Range(m, "MatchingType", 0, 2)
+	if err != nil {
+		return "", err
+	}
+	certificate, err := getHex(m, "Certificate", tlsaDigestLengths[matchingType])
+	if err != nil {
+		return "", err
+	}
+	return normalize("TLSA", fmt.Sprintf("%d %d %d %s", usage, selector, matchingType, certificate))
+}
+
+func (r *TLSA) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("TLSA", s)
+	if err != nil {
+		return nil, err
+	}
+	tlsa := rr.(*dns.TLSA)
+	return map[string]string{
+		"Usage":        strconv.Itoa(int(tlsa.Usage)),
+		"Selector":     strconv.Itoa(int(tlsa.Selector)),
+		"MatchingType": strconv.Itoa(int(tlsa.MatchingType)),
+		"Certificate":  tlsa.Certificate,
+	}, nil
+}
+
+// SSHFP records are SSH host key fingerprints (RFC 4255), so that SSH
+// clients can check a server's key without asking you
+
+type SSHFP struct{}
+
+// 1 is RSA, 2 is DSA, 3 is ECDSA, 4 is Ed25519 and 6 is Ed448
+var sshfpAlgorithms = map[uint8]bool{1: true, 2: true, 3: true, 4: true, 6: true}
+
+// fingerprint types: 1 is SHA-1 and 2 is SHA-256
+var sshfpDigestLengths = map[uint8]int{1: 20, 2: 32}
+
+func (r *SSHFP) ToPDNS(m map[string]string) (string, error) {
+	algorithm, err := getUint8(m, "Algorithm")
+	if err != nil {
+		return "", err
+	}
+	if !sshfpAlgorithms[algorithm] {
+		return "", &InvalidValueError{Name: "Algorithm", Message: fmt.Sprintf("Algorithm should be 1 (RSA), 2 (DSA), 3 (ECDSA), 4 (Ed25519) or 6 (Ed448): %d", algorithm)}
+	}
+	typ, err := getUint8Range(m, "Type", 1, 2)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := getHex(m, "Fingerprint", sshfpDigestLengths[typ])
+	if err != nil {
+		return "", err
+	}
+	// miekg/dns uppercases fingerprints, but PowerDNS uses lowercase
+	content := fmt.Sprintf("%d %d %s", algorithm, typ, fingerprint)
+	if _, err := newRR("SSHFP", content); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+func (r *SSHFP) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("SSHFP", s)
+	if err != nil {
+		return nil, err
+	}
+	sshfp := rr.(*dns.SSHFP)
+	return map[string]string{
+		"Algorithm":   strconv.Itoa(int(sshfp.Algorithm)),
+		"Type":        strconv.Itoa(int(sshfp.Type)),
+		"Fingerprint": strings.ToLower(sshfp.FingerPrint),
+	}, nil
+}
+
+// OPENPGPKEY records are OpenPGP public keys for email addresses (RFC 7929)
+
+type OPENPGPKEY struct{}
+
+func (r *OPENPGPKEY) ToPDNS(m map[string]string) (string, error) {
+	key, err := getBase64(m, "PublicKey")
+	if err != nil {
+		return "", err
+	}
+	return normalize("OPENPGPKEY", key)
+}
+
+func (r *OPENPGPKEY) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("OPENPGPKEY", s)
+	if err != nil {
+		return nil, err
 	}
-	// replace the first "." with a "@" in the rname
-	parts[1] = strings.Replace(parts[1], ".", "@", 1)
-	return map[string]string{"Mname": parts[0], "Rname": parts[1], "Serial": parts[2], "Refresh": parts[3], "Retry": parts[4], "Expire": parts[5], "Minimum": parts[6]}, nil
+	return map[string]string{"PublicKey": rr.(*dns.OPENPGPKEY).PublicKey}, nil
 }
 
-type SVCB struct{}
+// NAPTR records are for rewriting things like phone numbers into URIs
+// (RFC 3403), mostly for SIP
 
-func (r *SVCB) ToPDNS(m map[string]string) (string, error) {
+type NAPTR struct{}
+
+func (r *NAPTR) ToPDNS(m map[string]string) (string, error) {
+	order, err := getUint16(m, "Order")
+	if err != nil {
+		return "", err
+	}
+	preference, err := getUint16(m, "Preference")
+	if err != nil {
+		return "", err
+	}
+	flags, ok := m["Flags"]
+	if !ok {
+		return "", &ValueMissingError{Name: "Flags"}
+	}
+	if strings.Trim(flags, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		return "", &InvalidValueError{Name: "Flags", Message: fmt.Sprintf("NAPTR flags can only have letters and numbers: %s", flags)}
+	}
+	service, ok := m["Service"]
+	if !ok {
+		return "", &ValueMissingError{Name: "Service"}
+	}
+	regexp := m["Regexp"]
+	replacement := m["Replacement"]
+	if replacement == "" {
+		replacement = "."
+	}
+	replacement, err = getFqdn(map[string]string{"Replacement": replacement}, "Replacement")
+	if err != nil {
+		return "", err
+	}
+	// a NAPTR record either rewrites with a regexp or replaces the whole
+	// thing, not both (RFC 3403 section 4.1)
+	if regexp == "" && replacement == "." {
+		return "", &ValueMissingError{Name: "Regexp"}
+	}
+	if regexp != "" && replacement != "." {
+		return "", &InvalidValueError{Name: "Replacement", Message: "NAPTR records can have a regexp or a replacement, but not both"}
+	}
+	return normalize("NAPTR", fmt.Sprintf("%d %d %s %s %s %s", order, preference, quote(flags), quote(service), quote(regexp), replacement))
+}
+
+func (r *NAPTR) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("NAPTR", s)
+	if err != nil {
+		return nil, err
+	}
+	naptr := rr.(*dns.NAPTR)
+	return map[string]string{
+		"Order":       strconv.Itoa(int(naptr.Order)),
+		"Preference":  strconv.Itoa(int(naptr.Preference)),
+		"Flags":       unescape(naptr.Flags),
+		"Service":     unescape(naptr.Service),
+		"Regexp":      unescape(naptr.Regexp),
+		"Replacement": naptr.Replacement,
+	}, nil
+}
+
+type URI struct{}
+
+func (r *URI) ToPDNS(m map[string]string) (string, error) {
 	priority, err := getUint16(m, "Priority")
 	if err != nil {
 		return "", err
 	}
-	target, err := getFqdn(m, "Target")
+	weight, err := getUint16(m, "Weight")
+	if err != nil {
+		return "", err
+	}
+	target, ok := m["Target"]
+	if !ok {
+		return "", &ValueMissingError{Name: "Target"}
+	}
+	if u, err := url.Parse(target); err != nil || u.Scheme == "" {
+		return "", &InvalidValueError{Name: "Target", Message: fmt.Sprintf("invalid URI: %s", target)}
+	}
+	return normalize("URI", fmt.Sprintf("%d %d %s", priority, weight, quote(target)))
+}
+
+func (r *URI) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("URI", s)
+	if err != nil {
+		return nil, err
+	}
+	uri := rr.(*dns.URI)
+	return map[string]string{
+		"Priority": strconv.Itoa(int(uri.Priority)),
+		"Weight":   strconv.Itoa(int(uri.Weight)),
+		"Target":   unescape(uri.Target),
+	}, nil
+}
+
+// LOC records are a location on Earth (RFC 1876). In the UI the latitude and
+// longitude are in decimal degrees like on a map, and everything else is in
+// meters.
+
+type LOC struct{}
+
+// the defaults from RFC 1876 for the optional fields
+var locDefaults = map[string]string{"Size": "1", "HorizontalPrecision": "10000", "VerticalPrecision": "10"}
+
+func (r *LOC) ToPDNS(m map[string]string) (string, error) {
+	latitude, err := getFloat(m, "Latitude", -90, 90)
+	if err != nil {
+		return "", err
+	}
+	longitude, err := getFloat(m, "Longitude", -180, 180)
 	if err != nil {
 		return "", err
 	}
-	// params are optional
-	params, ok := m["Params"]
-	if !ok || params == "" {
-		return fmt.Sprintf("%d %s", priority, target), nil
+	altitude, err := getFloat(m, "Altitude", -100000, 42849672.95)
+	if err != nil {
+		return "", err
+	}
+	sizes := []float64{}
+	for _, key := range []string{"Size", "HorizontalPrecision", "VerticalPrecision"} {
+		values := map[string]string{key: strings.TrimSuffix(m[key], "m")}
+		if values[key] == "" {
+			values[key] = locDefaults[key]
+		}
+		size, err := getFloat(values, key, 0, 90000000)
+		if err != nil {
+			return "", err
+		}
+		sizes = append(sizes, size)
+	}
+	return normalize("LOC", fmt.Sprintf("%s %s %.2fm %.2fm %.2fm %.2fm",
+		degrees(latitude, "N", "S"), degrees(longitude, "E", "W"), altitude, sizes[0], sizes[1], sizes[2]))
+}
+
+func (r *LOC) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("LOC", s)
+	if err != nil {
+		return nil, err
+	}
+	loc := rr.(*dns.LOC)
+	// see RFC 1876 section 2 for how these are encoded
+	return map[string]string{
+		"Latitude":            formatFloat((float64(loc.Latitude)-dns.LOC_EQUATOR)/3600000, 6),
+		"Longitude":           formatFloat((float64(loc.Longitude)-dns.LOC_PRIMEMERIDIAN)/3600000, 6),
+		"Altitude":            formatFloat((float64(loc.Altitude)-dns.LOC_ALTITUDEBASE*100)/100, 2),
+		"Size":                formatFloat(locSize(loc.Size), 2),
+		"HorizontalPrecision": formatFloat(locSize(loc.HorizPre), 2),
+		"VerticalPrecision":   formatFloat(locSize(loc.VertPre), 2),
+	}, nil
+}
+
+func getFloat(m map[string]string, key string, min float64, max float64) (float64, error) {
+	val, ok := m[key]
+	if !ok {
+		return 0, &ValueMissingError{Name: key}
+	}
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(v) || v < min || v > max {
+		return 0, &InvalidValueError{Name: key, Message: fmt.Sprintf("%s should be a number from %s to %s: %s", key, formatFloat(min, 2), formatFloat(max, 2), val)}
+	}
+	return v, nil
+}
+
+// degrees formats decimal degrees as degrees, minutes and seconds, like
+// "52 22 23.000 N"
+func degrees(deg float64, positive string, negative string) string {
+	direction := positive
+	if deg < 0 {
+		direction = negative
+		deg = -deg
 	}
-	return fmt.Sprintf("%d %s %s", priority, target, params), nil
+	// LOC records are precise to a thousandth of a second
+	ms := int64(math.Round(deg * 3600000))
+	return fmt.Sprintf("%d %d %.3f %s", ms/3600000, ms%3600000/60000, float64(ms%60000)/1000, direction)
+}
+
+// locSize decodes a size in meters. They're stored in centimeters as
+// mantissa * 10^exponent, with the mantissa and exponent in one byte.
+func locSize(b uint8) float64 {
+	return float64(b>>4) * math.Pow10(int(b&0x0f)) / 100
 }
 
-func (r *SVCB) FromPDNS(s string) (map[string]string, error) {
-	parts := strings.Split(s, " ")
-	if len(parts) == 2 {
-		return map[string]string{"Priority": parts[0], "Target": parts[1]}, nil
+func formatFloat(f float64, decimals int) string {
+	return strconv.FormatFloat(f, 'f', decimals, 64)
+}
+
+// HINFO records describe a host's hardware and operating system
+
+type HINFO struct{}
+
+func (r *HINFO) ToPDNS(m map[string]string) (string, error) {
+	cpu, ok := m["Cpu"]
+	if !ok {
+		return "", &ValueMissingError{Name: "Cpu"}
 	}
-	if len(parts) == 3 {
-		return map[string]string{"Priority": parts[0], "Target": parts[1], "Params": parts[2]}, nil
+	os, ok := m["Os"]
+	if !ok {
+		return "", &ValueMissingError{Name: "Os"}
 	}
-	return nil, fmt.Errorf("invalid SVCB record: %s", s)
+	return normalize("HINFO", fmt.Sprintf("%s %s", quote(cpu), quote(os)))
+}
+
+func (r *HINFO) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("HINFO", s)
+	if err != nil {
+		return nil, err
+	}
+	hinfo := rr.(*dns.HINFO)
+	return map[string]string{"Cpu": unescape(hinfo.Cpu), "Os": unescape(hinfo.Os)}, nil
+}
+
+// RP records say who's responsible for a name (RFC 1183): an email address,
+// and optionally a TXT record with more information
+
+type RP struct{}
+
+func (r *RP) ToPDNS(m map[string]string) (string, error) {
+	mbox, err := getFqdn(m, "Mbox")
+	if err != nil {
+		return "", err
+	}
+	txt := m["Txt"]
+	if txt == "" {
+		txt = "."
+	}
+	txt, err = getFqdn(map[string]string{"Txt": txt}, "Txt")
+	if err != nil {
+		return "", err
+	}
+	// replace the first "@" with a "." in the mailbox, like in SOA records
+	mbox = strings.Replace(mbox, "@", ".", 1)
+	return normalize("RP", fmt.Sprintf("%s %s", mbox, txt))
+}
+
+func (r *RP) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("RP", s)
+	if err != nil {
+		return nil, err
+	}
+	rp := rr.(*dns.RP)
+	return map[string]string{"Mbox": strings.Replace(rp.Mbox, ".", "@", 1), "Txt": rp.Txt}, nil
+}
+
+// DNSKEY and DS records get created automatically when DNSSEC is turned on,
+// they can't be created by hand
+
+type DNSKEY struct{}
+
+func (r *DNSKEY) ToPDNS(m map[string]string) (string, error) {
+	return "", fmt.Errorf("DNSKEY records are created automatically when you turn on DNSSEC")
+}
+
+func (r *DNSKEY) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("DNSKEY", s)
+	if err != nil {
+		return nil, err
+	}
+	key := rr.(*dns.DNSKEY)
+	return map[string]string{
+		"Flags":     strconv.Itoa(int(key.Flags)),
+		"Protocol":  strconv.Itoa(int(key.Protocol)),
+		"Algorithm": strconv.Itoa(int(key.Algorithm)),
+		"PublicKey": key.PublicKey,
+	}, nil
+}
+
+type DS struct{}
+
+func (r *DS) ToPDNS(m map[string]string) (string, error) {
+	return "", fmt.Errorf("DS records are created automatically when you turn on DNSSEC")
+}
+
+func (r *DS) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("DS", s)
+	if err != nil {
+		return nil, err
+	}
+	ds := rr.(*dns.DS)
+	return map[string]string{
+		"KeyTag":     strconv.Itoa(int(ds.KeyTag)),
+		"Algorithm":  strconv.Itoa(int(ds.Algorithm)),
+		"DigestType": strconv.Itoa(int(ds.DigestType)),
+		"Digest":     ds.Digest,
+	}, nil
 }
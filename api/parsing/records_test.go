@@ -0,0 +1,171 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	sha256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA", 8)
+	testCases := []struct {
+		Type    string
+		Values  map[string]string
+		Content string
+	}{
+		{"A", map[string]string{"A": "1.2.3.4"}, "1.2.3.4"},
+		{"AAAA", map[string]string{"AAAA": "2001:db8::1"}, "2001:db8::1"},
+		{"CNAME", map[string]string{"Target": "example.com."}, "example.com."},
+		{"DNAME", map[string]string{"Target": "example.com."}, "example.com."},
+		{"ALIAS", map[string]string{"Target": "example.github.io."}, "example.github.io."},
+		{"MX", map[string]string{"Preference": "10", "Mx": "mail.example.com."}, "10 mail.example.com."},
+		{"NS", map[string]string{"Ns": "ns1.example.com."}, "ns1.example.com."},
+		{"PTR", map[string]string{"Ptr": "www.example.com."}, "www.example.com."},
+		{"SRV", map[string]string{"Priority": "10", "Weight": "20", "Port": "5060", "Target": "sip.example.com."}, "10 20 5060 sip.example.com."},
+		{"TXT", map[string]string{"Txt": "hello world"}, "\"hello world\""},
+		{"TXT", map[string]string{"Txt": "she said \"hi\" \\o/"}, "\"she said \\\"hi\\\" \\\\o/\""},
+		{"TXT", map[string]string{"Txt": "héllo"}, "\"h\\195\\169llo\""},
+		{"TXT", map[string]string{"Txt": dkim}, "\"" + dkim[:255] + "\" \"" + dkim[255:] + "\""},
+		{"CAA", map[string]string{"Flag": "0", "Tag": "issue", "Value": "letsencrypt.org"}, "0 issue \"letsencrypt.org\""},
+		{"CAA", map[string]string{"Flag": "128", "Tag": "issue", "Value": "ca.example.net; account=230123"}, "128 issue \"ca.example.net; account=230123\""},
+		{"SOA", map[string]string{"Mname": "ns1.example.com.", "Rname": "hostmaster@example.com.", "Serial": "2021010101", "Refresh": "3600", "Retry": "600", "Expire": "604800", "Minimum": "3600"}, "ns1.example.com. hostmaster.example.com. 2021010101 3600 600 604800 3600"},
+		{"SVCB", map[string]string{"Priority": "0", "Target": "example.com."}, "0 example.com."},
+		{"SVCB", map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2,h3", "Port": "8443"}, "1 . alpn=\"h2,h3\" port=\"8443\""},
+		{"HTTPS", map[string]string{"Priority": "1", "Target": ".", "Alpn": "h3", "NoDefaultAlpn": "true", "Ipv4Hint": "1.2.3.4,5.6.7.8"}, "1 . alpn=\"h3\" no-default-alpn=\"\" ipv4hint=\"1.2.3.4,5.6.7.8\""},
+		{"TLSA", map[string]string{"Usage": "3", "Selector": "1", "MatchingType": "1", "Certificate": sha256}, "3 1 1 " + sha256},
+		{"TLSA", map[string]string{"Usage": "2", "Selector": "0", "MatchingType": "0", "Certificate": "30820122300d06092a"}, "2 0 0 30820122300d06092a"},
+		{"SSHFP", map[string]string{"Algorithm": "4", "Type": "2", "Fingerprint": sha256}, "4 2 " + sha256},
+		{"OPENPGPKEY", map[string]string{"PublicKey": "mQINBFit2jsBEADrbl5vjVxYeAE0g0IDYCBpHirv1Sjlqxx5gjtPhb2YhvyDMXjq"}, "mQINBFit2jsBEADrbl5vjVxYeAE0g0IDYCBpHirv1Sjlqxx5gjtPhb2YhvyDMXjq"},
+		{"NAPTR", map[string]string{"Order": "100", "Preference": "10", "Flags": "S", "Service": "SIP+D2U", "Regexp": "", "Replacement": "_sip._udp.example.com."}, "100 10 \"S\" \"SIP+D2U\" \"\" _sip._udp.example.com."},
+		{"NAPTR", map[string]string{"Order": "100", "Preference": "10", "Flags": "u", "Service": "E2U+sip", "Regexp": "!^\\+44(.*)$!sip:\\1@example.com!", "Replacement": "."}, "100 10 \"u\" \"E2U+sip\" \"!^\\\\+44(.*)$!sip:\\\\1@example.com!\" ."},
+		{"URI", map[string]string{"Priority": "10", "Weight": "1", "Target": "ftp://ftp1.example.com/public"}, "10 1 \"ftp://ftp1.example.com/public\""},
+		{"LOC", map[string]string{"Latitude": "52.373056", "Longitude": "-4.892222", "Altitude": "-2.00", "Size": "1.00", "HorizontalPrecision": "10000.00", "VerticalPrecision": "10.00"}, "52 22 23.002 N 04 53 31.999 W -2m 1m 10000m 10m"},
+		{"HINFO", map[string]string{"Cpu": "PDP-11/70", "Os": "UNIX \"V7\""}, "\"PDP-11/70\" \"UNIX \\\"V7\\\"\""},
+		{"RP", map[string]string{"Mbox": "julia@example.com.", "Txt": "info.example.com."}, "julia.example.com. info.example.com."},
+		{"RP", map[string]string{"Mbox": "julia@example.com.", "Txt": "."}, "julia.example.com. ."},
+	}
+	for _, testCase := range testCases {
+		r, err := getType(testCase.Type)
+		fatalIfErr(t, err)
+		content, err := r.ToPDNS(testCase.Values)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Content, content, testCase.Type)
+		values, err := r.FromPDNS(content)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Values, values, testCase.Type)
+	}
+}
+
+// PowerDNS doesn't always store content the way we'd write it
+func TestFromPDNS(t *testing.T) {
+	testCases := []struct {
+		Type    string
+		Content string
+		Values  map[string]string
+	}{
+		{"AAAA", "2001:DB8:0:0::1", map[string]string{"AAAA": "2001:db8::1"}},
+		{"TXT", "\"hello\" \"world\"", map[string]string{"Txt": "helloworld"}},
+		{"TXT", "hello", map[string]string{"Txt": "hello"}},
+		{"CAA", "0 issue \"letsencrypt.org; validationmethods=dns-01\"", map[string]string{"Flag": "0", "Tag": "issue", "Value": "letsencrypt.org; validationmethods=dns-01"}},
+		{"SVCB", "1 . alpn=h2,h3 port=443 ipv6hint=2001:db8::1", map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2,h3", "Port": "443", "Ipv6Hint": "2001:db8::1"}},
+		{"DNSKEY", "257 3 13 dGVzdA==", map[string]string{"Flags": "257", "Protocol": "3", "Algorithm": "13", "PublicKey": "dGVzdA=="}},
+		{"SSHFP", "1 1 DA39A3EE5E6B4B0D3255BFEF95601890AFD80709", map[string]string{"Algorithm": "1", "Type": "1", "Fingerprint": "da39a3ee5e6b4b0d3255bfef95601890afd80709"}},
+		{"LOC", "51 30 12.748 N 0 7 39.611 W 0.00m", map[string]string{"Latitude": "51.503541", "Longitude": "-0.127670", "Altitude": "0.00", "Size": "1.00", "HorizontalPrecision": "10000.00", "VerticalPrecision": "10.00"}},
+		{"DS", "12345 13 2 abcdef", map[string]string{"KeyTag": "12345", "Algorithm": "13", "DigestType": "2", "Digest": "abcdef"}},
+	}
+	for _, testCase := range testCases {
+		values, err := ParseValues(testCase.Content, testCase.Type)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Values, values, testCase.Content)
+	}
+
+	_, err := ParseValues("10", "MX")
+	assert.NotNil(t, err)
+}
+
+func TestToPDNSError(t *testing.T) {
+	testCases := []struct {
+		Type   string
+		Values map[string]string
+	}{
+		{"AAAA", map[string]string{"AAAA": "1.2.3.4"}},
+		{"CAA", map[string]string{"Flag": "0", "Tag": "is sue", "Value": "letsencrypt.org"}},
+		{"SVCB", map[string]string{"Priority": "1", "Target": ".", "Params": "banana"}},
+		{"SVCB", map[string]string{"Priority": "1", "Target": ".", "Params": "alpn=h2; port=443"}},
+		{"TLSA", map[string]string{"Usage": "4", "Selector": "1", "MatchingType": "1", "Certificate": "abcd"}},
+		{"TLSA", map[string]string{"Usage": "3", "Selector": "2", "MatchingType": "1", "Certificate": "abcd"}},
+		// SHA-256 digests are 64 hex digits
+		{"TLSA", map[string]string{"Usage": "3", "Selector": "1", "MatchingType": "1", "Certificate": "abcd"}},
+		{"TLSA", map[string]string{"Usage": "3", "Selector": "1", "MatchingType": "0", "Certificate": "not hex"}},
+		{"SSHFP", map[string]string{"Algorithm": "5", "Type": "2", "Fingerprint": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		// SHA-1 fingerprints are 40 hex digits
+		{"SSHFP", map[string]string{"Algorithm": "1", "Type": "1", "Fingerprint": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		{"OPENPGPKEY", map[string]string{"PublicKey": "not base64!"}},
+		// NAPTR records need a regexp or a replacement, but not both
+		{"NAPTR", map[string]string{"Order": "100", "Preference": "10", "Flags": "S", "Service": "SIP+D2U", "Regexp": ""}},
+		{"NAPTR", map[string]string{"Order": "100", "Preference": "10", "Flags": "u", "Service": "E2U+sip", "Regexp": "!^.*$!sip:info@example.com!", "Replacement": "example.com"}},
+		{"NAPTR", map[string]string{"Order": "100", "Preference": "10", "Flags": "S U", "Service": "SIP+D2U", "Replacement": "example.com"}},
+		{"URI", map[string]string{"Priority": "10", "Weight": "1", "Target": "example.com"}},
+		{"LOC", map[string]string{"Latitude": "91", "Longitude": "0", "Altitude": "0"}},
+		{"LOC", map[string]string{"Latitude": "0", "Longitude": "-181", "Altitude": "0"}},
+		{"LOC", map[string]string{"Latitude": "0", "Longitude": "0", "Altitude": "high"}},
+		{"HINFO", map[string]string{"Cpu": "PDP-11/70"}},
+	}
+	for _, testCase := range testCases {
+		r, err := getType(testCase.Type)
+		fatalIfErr(t, err)
+		_, err = r.ToPDNS(testCase.Values)
+		assert.NotNil(t, err, testCase.Values)
+	}
+}
+
+func TestTXTStrings(t *testing.T) {
+	// a 2048-bit RSA key is too long for one string
+	dkim := "v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEArDl/v7xXEWgwJ3NJ2yayP0B41wNxy+F2X/XpNO15gP+7K5oBHwBlb3rUJDzZYehR9EAmstoG9mxV2eZo9PUaI0T7BUEAWM9M99tFFS7iTMh1zHvdmVwxh28XoZHlnT1rka9CojdUKgSqOqqCUo++re8+8RKDgy1tn7kjioEYUi+KXnhlRITl8bJHKoVDZsy68SP+mrt1e8x76Qaz+pN/9787lzq4qG+wyhe8kdlDCzs7igAwTSL5ONbKxgNaB+A9tmnwlHbLf++wB4/57iQdIF42nrKOouDfJNKwCcBHqItHdHMpUN8o8Cnjw3QaEwjzCqchrSDZi9PB4A/cRAXrKQIDAQAB"
+	long := strings.Repeat("a", 254)
+	testCases := []struct {
+		Txt     string
+		Strings []string
+	}{
+		{"", []string{""}},
+		{dkim, []string{dkim[:255], dkim[255:]}},
+		// escapes don't count towards the 255 bytes
+		{long + "\"\\", []string{long + "\"", "\\"}},
+		// UTF-8 characters stay in one piece
+		{long + "é", []string{long, "é"}},
+		{long + "a" + "é", []string{long + "a", "é"}},
+		// but anything else gets split at 255 bytes
+		{strings.Repeat("\xff", 300), []string{strings.Repeat("\xff", 255), strings.Repeat("\xff", 45)}},
+	}
+	for _, testCase := range testCases {
+		content, err := (&TXT{}).ToPDNS(map[string]string{"Txt": testCase.Txt})
+		fatalIfErr(t, err)
+		strs, err := TXTStrings(content)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Strings, strs)
+		values, err := (&TXT{}).FromPDNS(content)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Txt, values["Txt"])
+	}
+
+	// the strings are the same on the wire
+	content, err := (&TXT{}).ToPDNS(map[string]string{"Txt": dkim})
+	fatalIfErr(t, err)
+	assert.Equal(t, "\""+dkim[:255]+"\" \""+dkim[255:]+"\"", content)
+	rr, err := newRR("TXT", content)
+	fatalIfErr(t, err)
+	buf := make([]byte, 1024)
+	n, err := dns.PackRR(rr, buf, 0, nil, false)
+	fatalIfErr(t, err)
+	rdata := buf[n-int(rr.Header().Rdlength) : n]
+	assert.Equal(t, byte(255), rdata[0])
+	assert.Equal(t, dkim[:255], string(rdata[1:256]))
+	assert.Equal(t, byte(len(dkim)-255), rdata[256])
+	assert.Equal(t, dkim[255:], string(rdata[257:]))
+
+	_, err = (&TXT{}).ToPDNS(map[string]string{"Txt": strings.Repeat("a", 70000)})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,286 @@
+package parsing
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SVCB and HTTPS records have the same format, so they're both parsed as
+// SVCB. The SvcParams from RFC 9460 and RFC 9461 each get their own field,
+// and anything else (like key65000=...) goes in Params.
+
+type SVCB struct{}
+
+// svcbField is a SvcParam with its own field. check validates the field's
+// value and returns the SvcParam in presentation format, or "" if it
+// shouldn't be in the record at all.
+type svcbField struct {
+	name  string
+	key   dns.SVCBKey
+	check func(name string, value string) (string, error)
+}
+
+// in SvcParamKey order
+var svcbFields = []svcbField{
+	{"Mandatory", dns.SVCB_MANDATORY, checkMandatory},
+	{"Alpn", dns.SVCB_ALPN, checkAlpn},
+	{"NoDefaultAlpn", dns.SVCB_NO_DEFAULT_ALPN, checkNoDefaultAlpn},
+	{"Port", dns.SVCB_PORT, checkPort},
+	{"Ipv4Hint", dns.SVCB_IPV4HINT, checkIPv4Hint},
+	{"Ech", dns.SVCB_ECHCONFIG, checkECH},
+	{"Ipv6Hint", dns.SVCB_IPV6HINT, checkIPv6Hint},
+	{"DohPath", dns.SVCB_DOHPATH, checkDohPath},
+}
+
+// svcbFieldName is the field a SvcParam is edited in
+func svcbFieldName(key dns.SVCBKey) string {
+	for _, field := range svcbFields {
+		if field.key == key {
+			return field.name
+		}
+	}
+	return "Params"
+}
+
+func (r *SVCB) ToPDNS(m map[string]string) (string, error) {
+	priority, err := getUint16(m, "Priority")
+	if err != nil {
+		return "", err
+	}
+	target, err := getFqdn(m, "Target")
+	if err != nil {
+		return "", err
+	}
+	// all the params are optional, and empty fields aren't set
+	params := []string{}
+	for _, field := range svcbFields {
+		value := strings.TrimSpace(m[field.name])
+		if value == "" {
+			continue
+		}
+		param, err := field.check(field.name, value)
+		if err != nil {
+			return "", err
+		}
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+	other := m["Params"]
+	if strings.Contains(other, ";") {
+		// it would get parsed as a comment
+		return "", &InvalidValueError{Name: "Params", Message: fmt.Sprintf("invalid SVCB params: %s", other)}
+	}
+	if strings.TrimSpace(other) != "" {
+		params = append(params, other)
+	}
+	if len(params) == 0 {
+		return normalize("SVCB", fmt.Sprintf("%d %s", priority, target))
+	}
+	// RFC 9460 section 2.4.2
+	if priority == 0 {
+		return "", &InvalidValueError{Name: "Priority", Message: "SVCB records with priority 0 (AliasMode) can't have any parameters"}
+	}
+	rr, err := newRR("SVCB", fmt.Sprintf("%d %s %s", priority, target, strings.Join(params, " ")))
+	if err != nil {
+		// the other fields have been checked already
+		return "", &InvalidValueError{Name: "Params", Message: fmt.Sprintf("invalid SVCB params: %s", other)}
+	}
+	svcb := rr.(*dns.SVCB)
+	err = checkSVCBParams(svcb.Value)
+	if err != nil {
+		return "", err
+	}
+	// the keys can be in any order, but they go in increasing order on the
+	// wire, so we write them that way too
+	sort.SliceStable(svcb.Value, func(i, j int) bool { return svcb.Value[i].Key() < svcb.Value[j].Key() })
+	return rdata(svcb), nil
+}
+
+// checkSVCBParams checks the rules that are about more than one SvcParam
+// (RFC 9460 section 8 and 7.1.1)
+func checkSVCBParams(params []dns.SVCBKeyValue) error {
+	keys := map[dns.SVCBKey]bool{}
+	for _, kv := range params {
+		if keys[kv.Key()] {
+			return &InvalidValueError{Name: "Params", Message: fmt.Sprintf("the %s parameter is set twice", kv.Key())}
+		}
+		keys[kv.Key()] = true
+	}
+	for _, kv := range params {
+		mandatory, ok := kv.(*dns.SVCBMandatory)
+		if !ok {
+			continue
+		}
+		for _, key := range mandatory.Code {
+			if !keys[key] {
+				return &InvalidValueError{Name: "Mandatory", Message: fmt.Sprintf("%s is mandatory, but it isn't set", key)}
+			}
+		}
+	}
+	if keys[dns.SVCB_NO_DEFAULT_ALPN] && !keys[dns.SVCB_ALPN] {
+		return &InvalidValueError{Name: "Alpn", Message: "no-default-alpn needs alpn to be set, otherwise there aren't any protocols left"}
+	}
+	return nil
+}
+
+// svcbKey parses a SvcParamKey's name, like "alpn" or "key65000"
+func svcbKey(name string) (dns.SVCBKey, bool) {
+	if strings.HasPrefix(name, "key") {
+		n, err := strconv.ParseUint(name[len("key"):], 10, 16)
+		return dns.SVCBKey(n), err == nil && n != 65535
+	}
+	for key := dns.SVCB_MANDATORY; key <= dns.SVCB_OHTTP; key++ {
+		if key.String() == name {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
+func checkMandatory(name string, value string) (string, error) {
+	keys := []dns.SVCBKey{}
+	seen := map[dns.SVCBKey]bool{}
+	for _, s := range strings.Split(value, ",") {
+		key, ok := svcbKey(strings.TrimSpace(s))
+		switch {
+		case !ok:
+			return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("unknown SVCB parameter: %s", s)}
+		case key == dns.SVCB_MANDATORY:
+			return "", &InvalidValueError{Name: name, Message: "mandatory can't include itself"}
+		case seen[key]:
+			return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("%s is in mandatory twice", key)}
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	names := []string{}
+	for _, key := range keys {
+		names = append(names, key.String())
+	}
+	return "mandatory=" + strings.Join(names, ","), nil
+}
+
+func checkAlpn(name string, value string) (string, error) {
+	ids := []string{}
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || len(id) > 255 {
+			return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("invalid ALPN protocol: %q", id)}
+		}
+		for _, c := range []byte(id) {
+			// escaping commas in protocol IDs is possible, but nobody needs it
+			if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+				return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("invalid ALPN protocol: %q", id)}
+			}
+		}
+		ids = append(ids, id)
+	}
+	return "alpn=" + strings.Join(ids, ","), nil
+}
+
+func checkNoDefaultAlpn(name string, value string) (string, error) {
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("%s must be true or false", name)}
+	}
+	if !set {
+		return "", nil
+	}
+	return "no-default-alpn", nil
+}
+
+func checkPort(name string, value string) (string, error) {
+	port, err := toUint16(value)
+	if err != nil {
+		return "", &UInt16Error{Name: name, Value: value}
+	}
+	return fmt.Sprintf("port=%d", port), nil
+}
+
+func checkIPv4Hint(name string, value string) (string, error) {
+	ips := []string{}
+	for _, s := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil || ip.To4() == nil {
+			return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("invalid IPv4 address: %s", s)}
+		}
+		ips = append(ips, ip.String())
+	}
+	return "ipv4hint=" + strings.Join(ips, ","), nil
+}
+
+func checkIPv6Hint(name string, value string) (string, error) {
+	ips := []string{}
+	for _, s := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil || ip.To4() != nil {
+			return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("invalid IPv6 address: %s", s)}
+		}
+		ips = append(ips, ip.String())
+	}
+	return "ipv6hint=" + strings.Join(ips, ","), nil
+}
+
+func checkECH(name string, value string) (string, error) {
+	value = strings.Join(strings.Fields(value), "")
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("%s isn't valid base64", name)}
+	}
+	return "ech=" + value, nil
+}
+
+// dohpath is a URI template for DNS-over-HTTPS (RFC 9461 section 5)
+func checkDohPath(name string, value string) (string, error) {
+	if !strings.HasPrefix(value, "/") || !strings.Contains(value, "{?dns}") || strings.ContainsAny(value, " \t\"\\") {
+		return "", &InvalidValueError{Name: name, Message: fmt.Sprintf("dohpath needs to be a path with {?dns} in it, like /dns-query{?dns}: %s", value)}
+	}
+	return "dohpath=" + value, nil
+}
+
+func (r *SVCB) FromPDNS(s string) (map[string]string, error) {
+	rr, err := newRR("SVCB", s)
+	if err != nil {
+		return nil, err
+	}
+	svcb := rr.(*dns.SVCB)
+	m := map[string]string{"Priority": strconv.Itoa(int(svcb.Priority)), "Target": svcb.Target}
+	other := []string{}
+	for _, kv := range svcb.Value {
+		name := svcbFieldName(kv.Key())
+		_, set := m[name]
+		switch {
+		case name == "Params" || set:
+			// if a param is there twice, the second one goes in Params so
+			// that it doesn't disappear
+			other = append(other, formatSVCBParam(kv))
+		case kv.Key() == dns.SVCB_NO_DEFAULT_ALPN:
+			m[name] = "true"
+		default:
+			m[name] = kv.String()
+		}
+	}
+	if len(other) > 0 {
+		m["Params"] = strings.Join(other, " ")
+	}
+	return m, nil
+}
+
+func formatSVCBParam(kv dns.SVCBKeyValue) string {
+	param := kv.Key().String()
+	if value := kv.String(); value != "" {
+		if strings.ContainsAny(value, " \"") {
+			value = quote(value)
+		}
+		param += "=" + value
+	}
+	return param
+}
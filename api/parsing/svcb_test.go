@@ -0,0 +1,67 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSVCBParams(t *testing.T) {
+	testCases := []struct {
+		Values  map[string]string
+		Content string
+	}{
+		// empty fields aren't set
+		{map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2", "Port": "", "NoDefaultAlpn": "false", "Params": ""}, "1 . alpn=\"h2\""},
+		// the keys get sorted, wherever they come from
+		{map[string]string{"Priority": "1", "Target": ".", "Port": "443", "Params": "ipv4hint=1.2.3.4 alpn=h2"}, "1 . alpn=\"h2\" port=\"443\" ipv4hint=\"1.2.3.4\""},
+		{map[string]string{"Priority": "1", "Target": ".", "Mandatory": "port, alpn", "Alpn": "h2, h3", "Port": "443"}, "1 . mandatory=\"alpn,port\" alpn=\"h2,h3\" port=\"443\""},
+		{map[string]string{"Priority": "1", "Target": "doh.example.com.", "Alpn": "h2", "DohPath": "/dns-query{?dns}"}, "1 doh.example.com. alpn=\"h2\" dohpath=\"/dns-query{?dns}\""},
+		{map[string]string{"Priority": "1", "Target": ".", "Ech": "AEX+DQBB", "Ipv6Hint": "2001:DB8::1"}, "1 . ech=\"AEX+DQBB\" ipv6hint=\"2001:db8::1\""},
+		{map[string]string{"Priority": "1", "Target": ".", "Params": "key65000=hello"}, "1 . key65000=\"hello\""},
+	}
+	for _, testCase := range testCases {
+		content, err := (&SVCB{}).ToPDNS(testCase.Values)
+		fatalIfErr(t, err)
+		assert.Equal(t, testCase.Content, content)
+	}
+
+	// params we don't have fields for stay in Params
+	values, err := (&SVCB{}).FromPDNS("1 . alpn=h2 key65000=hello key65001")
+	fatalIfErr(t, err)
+	assert.Equal(t, map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2", "Params": "key65000=hello key65001"}, values)
+}
+
+func TestSVCBParamErrors(t *testing.T) {
+	testCases := []struct {
+		Values map[string]string
+		Field  string
+	}{
+		// AliasMode
+		{map[string]string{"Priority": "0", "Target": "example.com.", "Alpn": "h2"}, "value_Priority"},
+		{map[string]string{"Priority": "0", "Target": "example.com.", "Params": "key65000=hello"}, "value_Priority"},
+		{map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2", "Params": "alpn=h3"}, "value_Params"},
+		{map[string]string{"Priority": "1", "Target": ".", "Params": "port=443 port=8443"}, "value_Params"},
+		{map[string]string{"Priority": "1", "Target": ".", "Mandatory": "alpn,alpn", "Alpn": "h2"}, "value_Mandatory"},
+		{map[string]string{"Priority": "1", "Target": ".", "Mandatory": "mandatory"}, "value_Mandatory"},
+		{map[string]string{"Priority": "1", "Target": ".", "Mandatory": "banana"}, "value_Mandatory"},
+		{map[string]string{"Priority": "1", "Target": ".", "Mandatory": "port", "Alpn": "h2"}, "value_Mandatory"},
+		{map[string]string{"Priority": "1", "Target": ".", "Alpn": "h2,,h3"}, "value_Alpn"},
+		{map[string]string{"Priority": "1", "Target": ".", "NoDefaultAlpn": "true"}, "value_Alpn"},
+		{map[string]string{"Priority": "1", "Target": ".", "NoDefaultAlpn": "maybe", "Alpn": "h2"}, "value_NoDefaultAlpn"},
+		{map[string]string{"Priority": "1", "Target": ".", "Port": "99999"}, "value_Port"},
+		{map[string]string{"Priority": "1", "Target": ".", "Ipv4Hint": "2001:db8::1"}, "value_Ipv4Hint"},
+		{map[string]string{"Priority": "1", "Target": ".", "Ipv6Hint": "1.2.3.4"}, "value_Ipv6Hint"},
+		{map[string]string{"Priority": "1", "Target": ".", "Ech": "not base64!"}, "value_Ech"},
+		{map[string]string{"Priority": "1", "Target": ".", "DohPath": "/dns-query"}, "value_DohPath"},
+	}
+	for _, testCase := range testCases {
+		_, err := (&SVCB{}).ToPDNS(testCase.Values)
+		fieldErr, ok := err.(FieldError)
+		if !assert.True(t, ok, testCase.Values) {
+			continue
+		}
+		assert.Equal(t, testCase.Field, fieldErr.Field(), testCase.Values)
+		assert.Equal(t, CodeInvalidValue, fieldErr.Code())
+	}
+}
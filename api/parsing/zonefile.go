@@ -0,0 +1,245 @@
+package parsing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joeig/go-powerdns/v3"
+	"github.com/miekg/dns"
+)
+
+// ZoneFileRecord is one record from an imported zone file, along with the
+// line it started on so that errors from PowerDNS can point at it
+type ZoneFileRecord struct {
+	Line  int
+	RRset *powerdns.RRset
+}
+
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"error"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// zoneFileEntry is a record or a directive, which can span several lines if
+// it has parentheses in it
+type zoneFileEntry struct {
+	line int
+	text string
+}
+
+// splitZoneFile splits a zone file into entries. We parse each entry
+// separately so that we can report every bad line instead of stopping at the
+// first one like ZoneParser does.
+func splitZoneFile(text string) []zoneFileEntry {
+	entries := []zoneFileEntry{}
+	var current strings.Builder
+	start, line := 1, 1
+	depth := 0
+	quoted, escaped, comment := false, false, false
+	for _, c := range text {
+		if c == '\n' {
+			comment = false
+			if depth == 0 && !quoted {
+				if strings.TrimSpace(stripComment(current.String())) != "" {
+					entries = append(entries, zoneFileEntry{line: start, text: current.String()})
+				}
+				current.Reset()
+				line++
+				start = line
+				continue
+			}
+			line++
+		}
+		current.WriteRune(c)
+		switch {
+		case comment:
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == ';':
+			comment = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+	}
+	if strings.TrimSpace(stripComment(current.String())) != "" {
+		entries = append(entries, zoneFileEntry{line: start, text: current.String()})
+	}
+	return entries
+}
+
+// stripComment is only used to find empty lines, so it doesn't need to know
+// about semicolons in quoted strings
+func stripComment(s string) string {
+	if i := strings.Index(s, ";"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// cleanParseError removes miekg/dns's line numbers, since they're for the
+// single entry we parsed and not for the whole file
+func cleanParseError(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, " at line: "); i >= 0 {
+		msg = msg[:i]
+	}
+	return strings.TrimPrefix(msg, "dns: ")
+}
+
+// ParseZoneFile parses RFC 1035 master file text for a user's zone. Names
+// are relative to the user's zone unless there's an $ORIGIN. Records
+// without a TTL get the $TTL if there is one, otherwise the TTL of the
+// record before them (or 3600 for the first one). Every record goes through
+// the same validation as records created in the UI. SOA records are
+// skipped: the SOA is managed for you.
+func ParseZoneFile(text string, username string) ([]ZoneFileRecord, []LineError) {
+	zone := strings.ToLower(fullName("@", username))
+	records := []ZoneFileRecord{}
+	lineErrors := []LineError{}
+	// $ORIGIN and $TTL apply to everything after them, so we replay them
+	// before each entry. $GENERATE makes records, so it's handled like one.
+	directives := ""
+	previousOwner := ""
+	// without a $TTL, a record with no TTL gets the one from the record
+	// before it
+	previousTTL := uint32(3600)
+	for _, entry := range splitZoneFile(text) {
+		trimmed := strings.TrimSpace(entry.text)
+		text := entry.text
+		isDirective := strings.HasPrefix(trimmed, "$") && !strings.HasPrefix(strings.ToUpper(trimmed), "$GENERATE")
+		if !isDirective && (text[0] == ' ' || text[0] == '\t') && previousOwner != "" {
+			// a record with no name has the same name as the one before it
+			text = previousOwner + text
+		}
+		// miekg/dns doesn't know about ALIAS records, so they get parsed as
+		// CNAMEs (which have the same content) and turned back afterwards
+		alias := false
+		if !isDirective {
+			text, alias = aliasToCNAME(text)
+		}
+		zp := dns.NewZoneParser(strings.NewReader(directives+text+"\n"), zone, "")
+		zp.SetDefaultTTL(previousTTL)
+		rrs := []dns.RR{}
+		for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+			rrs = append(rrs, rr)
+		}
+		if err := zp.Err(); err != nil {
+			lineErrors = append(lineErrors, LineError{Line: entry.line, Message: cleanParseError(err), Code: CodeSyntaxError})
+			continue
+		}
+		if isDirective {
+			directives += trimmed + "\n"
+			continue
+		}
+		for _, rr := range rrs {
+			previousOwner = rr.Header().Name
+			previousTTL = rr.Header().Ttl
+			typ := dns.TypeToString[rr.Header().Rrtype]
+			if alias {
+				typ = "ALIAS"
+			}
+			rrset, err := zoneFileRRset(rr, typ, zone, username)
+			if err != nil {
+				lineError := LineError{Line: entry.line, Message: err.Error()}
+				var fieldErr FieldError
+				if errors.As(err, &fieldErr) {
+					lineError.Code = fieldErr.Code()
+				}
+				lineErrors = append(lineErrors, lineError)
+				continue
+			}
+			if rrset != nil {
+				records = append(records, ZoneFileRecord{Line: entry.line, RRset: rrset})
+			}
+		}
+	}
+	return records, lineErrors
+}
+
+// zoneFileTokens finds the fields in a line of a zone file
+var zoneFileTokens = regexp.MustCompile(`\S+`)
+
+// aliasToCNAME changes the type of an ALIAS record in a line of a zone file
+// to CNAME, and says whether it did. The type is the first field after the
+// owner name, TTL and class.
+func aliasToCNAME(text string) (string, bool) {
+	fields := zoneFileTokens.FindAllStringIndex(text, 4)
+	i := 0
+	if text[0] != ' ' && text[0] != '\t' {
+		// skip the owner name
+		i = 1
+	}
+	for ; i < len(fields); i++ {
+		field := text[fields[i][0]:fields[i][1]]
+		upper := strings.ToUpper(field)
+		isTTL := field[0] >= '0' && field[0] <= '9'
+		isClass := upper == "IN" || upper == "CH" || upper == "HS" || upper == "CS" || strings.HasPrefix(upper, "CLASS")
+		if isTTL || isClass {
+			continue
+		}
+		if upper != "ALIAS" {
+			return text, false
+		}
+		return text[:fields[i][0]] + "CNAME" + text[fields[i][1]:], true
+	}
+	return text, false
+}
+
+// zoneFileRRset converts a record from a zone file into the same form as the
+// record requests from the UI, and then parses it like one. typ is the
+// record's type, which is different from rr's for ALIAS records.
+func zoneFileRRset(rr dns.RR, typ string, zone string, username string) (*powerdns.RRset, error) {
+	hdr := rr.Header()
+	name := strings.ToLower(hdr.Name)
+	if name != zone && !strings.HasSuffix(name, "."+zone) {
+		return nil, &NameError{Message: fmt.Sprintf("%s isn't in your zone (%s)", hdr.Name, zone)}
+	}
+	if hdr.Class != dns.ClassINET {
+		return nil, &ClassError{Class: dns.Class(hdr.Class).String()}
+	}
+	if hdr.Rrtype == dns.TypeSOA {
+		return nil, nil
+	}
+	subdomain := "@"
+	if name != zone {
+		subdomain = strings.TrimSuffix(name, "."+zone)
+	}
+	values, err := ParseValues(rdata(rr), typ)
+	if err != nil {
+		return nil, err
+	}
+	jsRecord := map[string]string{
+		"subdomain": subdomain,
+		"type":      typ,
+		"ttl":       strconv.FormatUint(uint64(hdr.Ttl), 10),
+	}
+	for k, v := range values {
+		jsRecord["value_"+k] = v
+	}
+	rrset, err := ParseRecordRequest(jsRecord, username)
+	if err != nil {
+		return nil, err
+	}
+	// TXT records in the UI are one string, but the zone file might have
+	// split the record into several, so keep its strings
+	if _, ok := rr.(*dns.TXT); ok {
+		content := rdata(rr)
+		rrset.Records[0].Content = &content
+	}
+	return rrset, nil
+}
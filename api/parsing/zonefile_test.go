@@ -0,0 +1,121 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseZoneFile(t *testing.T) {
+	zoneFile := `$TTL 300
+; a comment
+@       IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300
+@          A     1.2.3.4
+           AAAA  2001:db8::1
+www  60    CNAME @
+mail       MX    10 mail.example.com.
+@          CAA   0 issue "letsencrypt.org"
+txt        TXT   ( "hello"
+                   "world" ) ; a multi-line record
+$ORIGIN sub.test.messwithdns.com.
+_sip._tcp  SRV   10 20 5060 sip.example.com.
+`
+	records, lineErrors := ParseZoneFile(zoneFile, "test")
+	assert.Equal(t, []LineError{}, lineErrors)
+	type result struct {
+		Line    int
+		Name    string
+		Type    string
+		TTL     uint32
+		Content string
+	}
+	results := []result{}
+	for _, record := range records {
+		rrset := record.RRset
+		results = append(results, result{record.Line, *rrset.Name, string(*rrset.Type), *rrset.TTL, *rrset.Records[0].Content})
+	}
+	assert.Equal(t, []result{
+		{4, "test.messwithdns.com.", "A", 300, "1.2.3.4"},
+		{5, "test.messwithdns.com.", "AAAA", 300, "2001:db8::1"},
+		{6, "www.test.messwithdns.com.", "CNAME", 60, "test.messwithdns.com."},
+		{7, "mail.test.messwithdns.com.", "MX", 300, "10 mail.example.com."},
+		{8, "test.messwithdns.com.", "CAA", 300, "0 issue \"letsencrypt.org\""},
+		{9, "txt.test.messwithdns.com.", "TXT", 300, "\"hello\" \"world\""},
+		{12, "_sip._tcp.sub.test.messwithdns.com.", "SRV", 300, "10 20 5060 sip.example.com."},
+	}, results)
+}
+
+func TestParseZoneFileTTL(t *testing.T) {
+	ttls := func(zoneFile string) []uint32 {
+		records, lineErrors := ParseZoneFile(zoneFile, "test")
+		assert.Equal(t, []LineError{}, lineErrors)
+		result := []uint32{}
+		for _, record := range records {
+			result = append(result, *record.RRset.TTL)
+		}
+		return result
+	}
+	// the TTL carries over from the record before
+	assert.Equal(t, []uint32{300, 300, 60}, ttls("www 300 IN A 1.2.3.4\nfoo IN A 1.2.3.5\nbar 60 IN A 1.2.3.6\n"))
+	// unless there's a $TTL
+	assert.Equal(t, []uint32{300, 120}, ttls("$TTL 120\nwww 300 IN A 1.2.3.4\nfoo IN A 1.2.3.5\n"))
+	assert.Equal(t, []uint32{3600}, ttls("www IN A 1.2.3.4\n"))
+}
+
+func TestParseZoneFileAlias(t *testing.T) {
+	zoneFile := `@ 3600 IN ALIAS example.com.
+@ alias example.org.
+www 60 IN TXT "ALIAS"
+`
+	records, lineErrors := ParseZoneFile(zoneFile, "test")
+	assert.Equal(t, []LineError{}, lineErrors)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "ALIAS", string(*records[0].RRset.Type))
+	assert.Equal(t, "example.com.", *records[0].RRset.Records[0].Content)
+	assert.Equal(t, "ALIAS", string(*records[1].RRset.Type))
+	assert.Equal(t, "TXT", string(*records[2].RRset.Type))
+
+	// ALIAS records still have to be at @
+	_, lineErrors = ParseZoneFile("www ALIAS example.com.\n", "test")
+	assert.Equal(t, CodeInvalidName, lineErrors[0].Code)
+}
+
+func TestParseZoneFileGenerate(t *testing.T) {
+	zoneFile := `$GENERATE 1-3 host$ A 10.0.0.$
+www A 1.2.3.4
+`
+	records, lineErrors := ParseZoneFile(zoneFile, "test")
+	assert.Equal(t, []LineError{}, lineErrors)
+	lines := map[string]int{}
+	for _, record := range records {
+		lines[*record.RRset.Name] = record.Line
+	}
+	assert.Equal(t, map[string]int{
+		"host1.test.messwithdns.com.": 1,
+		"host2.test.messwithdns.com.": 1,
+		"host3.test.messwithdns.com.": 1,
+		"www.test.messwithdns.com.":   2,
+	}, lines)
+	assert.Equal(t, 4, len(records))
+}
+
+func TestParseZoneFileErrors(t *testing.T) {
+	zoneFile := `www A 1.2.3.x
+ok A 1.2.3.4
+example.com. A 1.2.3.4
+key DNSKEY 257 3 13 dGVzdA==
+$TTL banana
+$INCLUDE /etc/passwd
+chaos CH TXT "hello"
+`
+	records, lineErrors := ParseZoneFile(zoneFile, "test")
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, []LineError{
+		{1, "bad A A: \"1.2.3.x\"", CodeSyntaxError},
+		{3, "example.com. isn't in your zone (test.messwithdns.com.)", CodeInvalidName},
+		{4, "DNSKEY records are created automatically when you turn on DNSSEC", ""},
+		{5, "expecting $TTL value, not this...: \"banana\"", CodeSyntaxError},
+		{6, "$INCLUDE directive not allowed: \"/etc/passwd\"", CodeSyntaxError},
+		{7, "only IN records are supported, got CH", CodeInvalidValue},
+	}, lineErrors)
+}
@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter for DNS queries, keyed by the
+// client's IP address (or the network it's in, if you set a prefix length)
+type Limiter struct {
+	mu          sync.Mutex
+	config      Config
+	buckets     map[netip.Prefix]*bucket
+	allowed     uint64
+	limited     uint64
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type Config struct {
+	// QPS is how many queries per second each client gets, 0 means no limit
+	QPS float64
+	// Burst is how many queries a client can send at once
+	Burst float64
+	// IPv4PrefixLen and IPv6PrefixLen control how clients are grouped: 32
+	// and 128 limit each IP separately, 24 and 56 limit whole networks
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+type bucket struct {
+	tokenBucket
+	limited  uint64
+	lastSeen time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time that has passed, and then takes a
+// token if there's one left
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full says whether enough time has passed that the bucket would be full
+func (b *tokenBucket) full(now time.Time, rate float64, burst float64) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+type Stats struct {
+	Allowed uint64         `json:"allowed"`
+	Limited uint64         `json:"limited"`
+	Clients []ClientCounts `json:"clients"`
+}
+
+type ClientCounts struct {
+	Prefix  string    `json:"prefix"`
+	Limited uint64    `json:"limited"`
+	Last    time.Time `json:"last_limited"`
+}
+
+func New(config Config) *Limiter {
+	if config.IPv4PrefixLen == 0 {
+		config.IPv4PrefixLen = 32
+	}
+	if config.IPv6PrefixLen == 0 {
+		config.IPv6PrefixLen = 128
+	}
+	if config.Burst < 1 {
+		config.Burst = config.QPS
+	}
+	return &Limiter{
+		config:  config,
+		buckets: map[netip.Prefix]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func clientPrefix(ip net.IP, ipv4PrefixLen int, ipv6PrefixLen int) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+	bits := ipv6PrefixLen
+	if addr.Is4() {
+		bits = ipv4PrefixLen
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		// invalid IP, put them all in the same bucket
+		return netip.Prefix{}
+	}
+	return prefix
+}
+
+// Allow returns false if the client has used up its queries
+func (l *Limiter) Allow(ip net.IP) bool {
+	if l == nil || l.config.QPS <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.maybeCleanup(now)
+
+	key := clientPrefix(ip, l.config.IPv4PrefixLen, l.config.IPv6PrefixLen)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokenBucket: tokenBucket{tokens: l.config.Burst, last: now}}
+		l.buckets[key] = b
+	}
+	if !b.take(now, l.config.QPS, l.config.Burst) {
+		b.limited++
+		b.lastSeen = now
+		l.limited++
+		return false
+	}
+	l.allowed++
+	return true
+}
+
+// maybeCleanup forgets about clients whose buckets have filled back up, so
+// that the map doesn't grow forever. Clients that got limited are kept
+// around for a while longer so that they show up in Stats.
+func (l *Limiter) maybeCleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+	for key, b := range l.buckets {
+		if !b.full(now, l.config.QPS, l.config.Burst) {
+			continue
+		}
+		if b.limited > 0 && now.Sub(b.lastSeen) < time.Hour {
+			continue
+		}
+		delete(l.buckets, key)
+	}
+}
+
+// Stats returns how many queries were allowed/limited, and which clients
+// got limited (most limited first)
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{Clients: []ClientCounts{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := Stats{Allowed: l.allowed, Limited: l.limited, Clients: []ClientCounts{}}
+	for key, b := range l.buckets {
+		if b.limited == 0 {
+			continue
+		}
+		stats.Clients = append(stats.Clients, ClientCounts{Prefix: key.String(), Limited: b.limited, Last: b.lastSeen})
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool {
+		return stats.Clients[i].Limited > stats.Clients[j].Limited
+	})
+	return stats
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(config Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(config)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter(t *testing.T) {
+	l, clock := newTestLimiter(Config{QPS: 10, Burst: 5})
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.Allow(ip))
+	}
+	assert.False(t, l.Allow(ip))
+	// other IPs aren't affected
+	assert.True(t, l.Allow(net.ParseIP("192.0.2.2")))
+
+	// 100ms is enough to get 1 more token
+	clock.advance(100 * time.Millisecond)
+	assert.True(t, l.Allow(ip))
+	assert.False(t, l.Allow(ip))
+
+	stats := l.Stats()
+	assert.Equal(t, uint64(7), stats.Allowed)
+	assert.Equal(t, uint64(2), stats.Limited)
+	assert.Equal(t, 1, len(stats.Clients))
+	assert.Equal(t, "192.0.2.1/32", stats.Clients[0].Prefix)
+	assert.Equal(t, uint64(2), stats.Clients[0].Limited)
+}
+
+func TestLimiterPrefix(t *testing.T) {
+	l, _ := newTestLimiter(Config{QPS: 1, Burst: 2, IPv4PrefixLen: 24, IPv6PrefixLen: 56})
+	assert.True(t, l.Allow(net.ParseIP("192.0.2.1")))
+	assert.True(t, l.Allow(net.ParseIP("192.0.2.2")))
+	assert.False(t, l.Allow(net.ParseIP("192.0.2.3")))
+	assert.True(t, l.Allow(net.ParseIP("198.51.100.1")))
+
+	assert.True(t, l.Allow(net.ParseIP("2001:db8:0:1::1")))
+	assert.True(t, l.Allow(net.ParseIP("2001:db8:0:2::1")))
+	assert.False(t, l.Allow(net.ParseIP("2001:db8:0:3::1")))
+	prefixes := []string{}
+	for _, c := range l.Stats().Clients {
+		prefixes = append(prefixes, c.Prefix)
+	}
+	assert.ElementsMatch(t, []string{"192.0.2.0/24", "2001:db8::/56"}, prefixes)
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l, _ := newTestLimiter(Config{})
+	for i := 0; i < 1000; i++ {
+		assert.True(t, l.Allow(net.ParseIP("192.0.2.1")))
+	}
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	l, clock := newTestLimiter(Config{QPS: 10, Burst: 1})
+	l.Allow(net.ParseIP("192.0.2.1"))
+	l.Allow(net.ParseIP("192.0.2.2"))
+	l.Allow(net.ParseIP("192.0.2.2"))
+	clock.advance(2 * time.Minute)
+	l.Allow(net.ParseIP("192.0.2.3"))
+	// 192.0.2.1 is forgotten, but 192.0.2.2 got limited so we keep it
+	assert.Equal(t, 2, len(l.buckets))
+	assert.Equal(t, 1, len(l.Stats().Clients))
+}
@@ -0,0 +1,239 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RRL is BIND-style response rate limiting. Limiting queries per IP isn't
+// enough to stop us from being used in a reflection attack (the attacker
+// spoofs lots of queries "from" the victim), so RRL limits identical
+// responses going to the same network instead.
+//
+// When a bucket is empty, most responses get dropped, but every Nth one
+// "slips" through as an empty truncated response, so that real clients
+// behind the same network can retry over TCP.
+type RRL struct {
+	mu          sync.Mutex
+	config      RRLConfig
+	buckets     map[string]*rrlBucket
+	counts      RRLStats
+	lastCleanup time.Time
+	now         func() time.Time
+	// finds the wildcard record that answers for a name, see SetWildcards
+	wildcards func(name string) string
+	// in dry run mode we log what we would have limited, but not so much
+	// that an attack can flood the logs
+	logf       func(format string, args ...any)
+	logLimiter tokenBucket
+}
+
+// at most this many dry run log lines per second (with bursts of up to
+// dryRunLogBurst)
+const (
+	dryRunLogsPerSecond = 1
+	dryRunLogBurst      = 10
+)
+
+type RRLConfig struct {
+	// 0 means RRL is off
+	ResponsesPerSecond float64
+	// every Slip-th limited response is sent truncated instead of being
+	// dropped. 0 means always drop, 1 means always slip
+	Slip int
+	// how responses get grouped by client, BIND uses /24 and /56
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+	// DryRun counts what would have been limited without limiting anything
+	DryRun bool
+}
+
+type RRLStats struct {
+	Sent    uint64 `json:"sent"`
+	Slipped uint64 `json:"slipped"`
+	Dropped uint64 `json:"dropped"`
+	// what would have been slipped/dropped in dry run mode
+	DryRunSlipped uint64 `json:"dry_run_slipped"`
+	DryRunDropped uint64 `json:"dry_run_dropped"`
+}
+
+type rrlBucket struct {
+	tokenBucket
+	// how many responses in this bucket got limited, for slipping
+	limited uint64
+}
+
+type Action int
+
+const (
+	Send Action = iota
+	Slip
+	Drop
+)
+
+func (a Action) String() string {
+	switch a {
+	case Slip:
+		return "slip"
+	case Drop:
+		return "drop"
+	}
+	return "send"
+}
+
+func NewRRL(config RRLConfig) *RRL {
+	if config.IPv4PrefixLen == 0 {
+		config.IPv4PrefixLen = 24
+	}
+	if config.IPv6PrefixLen == 0 {
+		config.IPv6PrefixLen = 56
+	}
+	return &RRL{
+		config:     config,
+		buckets:    map[string]*rrlBucket{},
+		now:        time.Now,
+		logf:       func(format string, args ...any) { fmt.Printf(format, args...) },
+		logLimiter: tokenBucket{tokens: dryRunLogBurst},
+	}
+}
+
+// SetWildcards tells RRL how to find the wildcard record (like
+// "*.orange.messwithdns.com.") that answers for a name, or "" if there isn't
+// one. Without it, we can only tell that an answer came from a wildcard if
+// it's signed.
+func (r *RRL) SetWildcards(lookup func(name string) string) {
+	r.wildcards = lookup
+}
+
+// rrlKey groups responses the same way BIND does: normal answers by name and
+// type, NXDOMAINs by zone (so that random subdomains don't each get their own
+// bucket), and all errors together. Answers from a wildcard are grouped by
+// the wildcard's name, for the same reason.
+func (r *RRL) rrlKey(response *dns.Msg) string {
+	q := response.Question[0]
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+		name := strings.ToLower(q.Name)
+		if wildcard := r.wildcard(response); wildcard != "" {
+			name = strings.ToLower(wildcard)
+		}
+		if len(response.Answer) == 0 {
+			return fmt.Sprintf("nodata/%s", name)
+		}
+		return fmt.Sprintf("answer/%s/%s", name, dns.TypeToString[q.Qtype])
+	case dns.RcodeNameError:
+		zone := q.Name
+		for _, rr := range response.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				zone = soa.Hdr.Name
+			}
+		}
+		return fmt.Sprintf("nxdomain/%s", strings.ToLower(zone))
+	}
+	return "error"
+}
+
+// wildcard is the wildcard record the response's answer came from, or ""
+func (r *RRL) wildcard(response *dns.Msg) string {
+	name := response.Question[0].Name
+	if r.wildcards != nil {
+		return r.wildcards(name)
+	}
+	// the RRSIG for an answer from a wildcard has fewer labels than the name
+	// (RFC 4035 section 5.3.4)
+	labels := dns.SplitDomainName(name)
+	for _, rr := range response.Answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || !strings.EqualFold(sig.Hdr.Name, name) || int(sig.Labels) >= len(labels) {
+			continue
+		}
+		return "*." + dns.Fqdn(strings.Join(labels[len(labels)-int(sig.Labels):], "."))
+	}
+	return ""
+}
+
+// Check decides what to do with a response we're about to send over UDP
+func (r *RRL) Check(ip net.IP, response *dns.Msg) Action {
+	if r == nil || r.config.ResponsesPerSecond <= 0 || len(response.Question) == 0 {
+		return Send
+	}
+	// the wildcard lookup can be slow (it might need to ask PowerDNS), so
+	// we don't hold the lock for it
+	prefix := clientPrefix(ip, r.config.IPv4PrefixLen, r.config.IPv6PrefixLen).String()
+	key := prefix + "/" + r.rrlKey(response)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.maybeCleanup(now)
+
+	rate := r.config.ResponsesPerSecond
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &rrlBucket{tokenBucket: tokenBucket{tokens: rate, last: now}}
+		r.buckets[key] = b
+	}
+	if b.take(now, rate, rate) {
+		r.counts.Sent++
+		return Send
+	}
+
+	action := Drop
+	b.limited++
+	if r.config.Slip > 0 && b.limited%uint64(r.config.Slip) == 0 {
+		action = Slip
+	}
+	if r.config.DryRun {
+		if r.logLimiter.take(now, dryRunLogsPerSecond, dryRunLogBurst) {
+			r.logf("rrl dry run: would %s response to %s (bucket %s)\n", action, prefix, key)
+		}
+		r.counts.Sent++
+		if action == Slip {
+			r.counts.DryRunSlipped++
+		} else {
+			r.counts.DryRunDropped++
+		}
+		return Send
+	}
+	if action == Slip {
+		r.counts.Slipped++
+	} else {
+		r.counts.Dropped++
+	}
+	return action
+}
+
+func (r *RRL) maybeCleanup(now time.Time) {
+	if now.Sub(r.lastCleanup) < time.Minute {
+		return
+	}
+	r.lastCleanup = now
+	rate := r.config.ResponsesPerSecond
+	for key, b := range r.buckets {
+		if b.full(now, rate, rate) {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+func (r *RRL) Stats() RRLStats {
+	if r == nil {
+		return RRLStats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts
+}
+
+// Truncated is the response we send instead when a response slips
+func Truncated(query *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(query)
+	m.Truncated = true
+	return m
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRRL(config RRLConfig) (*RRL, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := NewRRL(config)
+	r.now = clock.now
+	return r, clock
+}
+
+func testResponse(name string, rcode int) *dns.Msg {
+	query := new(dns.Msg)
+	query.SetQuestion(name, dns.TypeA)
+	m := new(dns.Msg)
+	m.SetRcode(query, rcode)
+	if rcode == dns.RcodeSuccess {
+		rr, _ := dns.NewRR(name + " 60 IN A 1.2.3.4")
+		m.Answer = []dns.RR{rr}
+	}
+	if rcode == dns.RcodeNameError {
+		soa, _ := dns.NewRR("messwithdns.com. 60 IN SOA ns1.messwithdns.com. hostmaster.messwithdns.com. 1 10800 3600 604800 3600")
+		m.Ns = []dns.RR{soa}
+	}
+	return m
+}
+
+func TestRRL(t *testing.T) {
+	r, clock := newTestRRL(RRLConfig{ResponsesPerSecond: 2, Slip: 2})
+	response := testResponse("orange.messwithdns.com.", dns.RcodeSuccess)
+	victim := net.ParseIP("192.0.2.1")
+
+	actions := []Action{}
+	for i := 0; i < 6; i++ {
+		actions = append(actions, r.Check(victim, response))
+	}
+	assert.Equal(t, []Action{Send, Send, Drop, Slip, Drop, Slip}, actions)
+
+	// same network, same bucket
+	assert.Equal(t, Drop, r.Check(net.ParseIP("192.0.2.200"), response))
+	// different network, different bucket
+	assert.Equal(t, Send, r.Check(net.ParseIP("198.51.100.1"), response))
+	// different name, different bucket
+	assert.Equal(t, Send, r.Check(victim, testResponse("purple.messwithdns.com.", dns.RcodeSuccess)))
+
+	clock.advance(time.Second)
+	assert.Equal(t, Send, r.Check(victim, response))
+
+	assert.Equal(t, RRLStats{Sent: 5, Slipped: 2, Dropped: 3}, r.Stats())
+}
+
+func TestRRLNXDOMAIN(t *testing.T) {
+	r, _ := newTestRRL(RRLConfig{ResponsesPerSecond: 1})
+	victim := net.ParseIP("192.0.2.1")
+	// random subdomains all go in the same bucket
+	assert.Equal(t, Send, r.Check(victim, testResponse("a.messwithdns.com.", dns.RcodeNameError)))
+	assert.Equal(t, Drop, r.Check(victim, testResponse("b.messwithdns.com.", dns.RcodeNameError)))
+	// and so do errors
+	assert.Equal(t, Send, r.Check(victim, testResponse("a.messwithdns.com.", dns.RcodeServerFailure)))
+	assert.Equal(t, Drop, r.Check(victim, testResponse("b.example.com.", dns.RcodeRefused)))
+}
+
+func TestRRLWildcard(t *testing.T) {
+	r, _ := newTestRRL(RRLConfig{ResponsesPerSecond: 1})
+	victim := net.ParseIP("192.0.2.1")
+	r.SetWildcards(func(name string) string {
+		if strings.HasSuffix(name, ".wild.messwithdns.com.") {
+			return "*.wild.messwithdns.com."
+		}
+		return ""
+	})
+	// random names that match the same wildcard all go in the same bucket
+	assert.Equal(t, Send, r.Check(victim, testResponse("a.wild.messwithdns.com.", dns.RcodeSuccess)))
+	assert.Equal(t, Drop, r.Check(victim, testResponse("b.wild.messwithdns.com.", dns.RcodeSuccess)))
+	assert.Equal(t, Send, r.Check(victim, testResponse("orange.messwithdns.com.", dns.RcodeSuccess)))
+}
+
+func TestRRLWildcardRRSIG(t *testing.T) {
+	r, _ := newTestRRL(RRLConfig{ResponsesPerSecond: 1})
+	victim := net.ParseIP("192.0.2.1")
+	signed := func(name string) *dns.Msg {
+		m := testResponse(name, dns.RcodeSuccess)
+		// the signature is for *.wild.messwithdns.com., which has 3 labels
+		sig, _ := dns.NewRR(name + " 60 IN RRSIG A 13 3 60 20240201000000 20240101000000 12345 messwithdns.com. c2ln")
+		m.Answer = append(m.Answer, sig)
+		return m
+	}
+	assert.Equal(t, Send, r.Check(victim, signed("a.wild.messwithdns.com.")))
+	assert.Equal(t, Drop, r.Check(victim, signed("b.wild.messwithdns.com.")))
+	// without a wildcard, the name is what counts
+	assert.Equal(t, Send, r.Check(victim, testResponse("a.wild.messwithdns.com.", dns.RcodeSuccess)))
+}
+
+func TestRRLDryRun(t *testing.T) {
+	r, _ := newTestRRL(RRLConfig{ResponsesPerSecond: 1, Slip: 2, DryRun: true})
+	response := testResponse("orange.messwithdns.com.", dns.RcodeSuccess)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, Send, r.Check(net.ParseIP("192.0.2.1"), response))
+	}
+	assert.Equal(t, RRLStats{Sent: 3, DryRunDropped: 1, DryRunSlipped: 1}, r.Stats())
+}
+
+func TestRRLDryRunLogging(t *testing.T) {
+	r, clock := newTestRRL(RRLConfig{ResponsesPerSecond: 1, Slip: 2, DryRun: true})
+	logs := []string{}
+	r.logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	response := testResponse("orange.messwithdns.com.", dns.RcodeSuccess)
+	victim := net.ParseIP("192.0.2.1")
+	r.Check(victim, response)
+	assert.Empty(t, logs)
+	r.Check(victim, response)
+	assert.Equal(t, []string{"rrl dry run: would drop response to 192.0.2.0/24 (bucket 192.0.2.0/24/answer/orange.messwithdns.com./A)\n"}, logs)
+
+	// an attack can't flood the logs
+	for i := 0; i < 1000; i++ {
+		r.Check(victim, response)
+	}
+	assert.Equal(t, dryRunLogBurst, len(logs))
+	clock.advance(time.Second)
+	r.Check(victim, response)
+	r.Check(victim, response)
+	assert.Equal(t, dryRunLogBurst+1, len(logs))
+}
+
+func TestRRLDisabled(t *testing.T) {
+	r, _ := newTestRRL(RRLConfig{})
+	response := testResponse("orange.messwithdns.com.", dns.RcodeSuccess)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, Send, r.Check(net.ParseIP("192.0.2.1"), response))
+	}
+}
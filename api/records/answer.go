@@ -0,0 +1,444 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// how many CNAMEs we'll follow inside our own zones
+const maxCNAMEChain = 8
+
+const (
+	// same as the resolver in our PowerDNS config, which PowerDNS uses for
+	// ALIAS records
+	defaultAliasResolver = "1.1.1.1:53"
+	aliasTimeout         = 2 * time.Second
+	// the Resolver in an AliasResolution when the target is in our zones
+	AliasResolverZone = "zone"
+)
+
+// AliasResolution is how we answered a query for a name with an ALIAS
+// record, so that the request log can show it
+type AliasResolution struct {
+	Target string
+	// AliasResolverZone if the target is in one of our zones, otherwise the
+	// address of the resolver we asked
+	Resolver string
+	// the target's records, before we renamed them to the queried name
+	Records []dns.RR
+	Err     error
+
+	// what to answer for, and the zone it's in
+	name string
+	ttl  uint32
+	zone *localZone
+}
+
+// SetAliasResolver sets the resolver that ALIAS targets outside our zones
+// get looked up with, as host:port
+func (b *LocalBackend) SetAliasResolver(address string) {
+	b.aliasResolver = address
+}
+
+// Answer answers a DNS query from the local zones, the way PowerDNS would
+// if the zones were in PowerDNS
+func (b *LocalBackend) Answer(query *dns.Msg) *dns.Msg {
+	m, _ := b.AnswerQuery(query)
+	return m
+}
+
+// AnswerQuery is Answer, but it also says how an ALIAS record got resolved
+// if the query needed one (otherwise the resolution is nil)
+func (b *LocalBackend) AnswerQuery(query *dns.Msg) (*dns.Msg, *AliasResolution) {
+	m := new(dns.Msg)
+	m.SetReply(query)
+	if query.Opcode != dns.OpcodeQuery {
+		m.SetRcode(query, dns.RcodeNotImplemented)
+		return m, nil
+	}
+	if len(query.Question) != 1 {
+		m.SetRcode(query, dns.RcodeFormatError)
+		return m, nil
+	}
+	// DNSSEC records only get sent if the client asks for them
+	do := false
+	if opt := query.IsEdns0(); opt != nil {
+		do = opt.Do()
+		m.SetEdns0(1232, do)
+	}
+	q := query.Question[0]
+
+	b.mu.RLock()
+	alias := b.answer(m, q, do)
+	b.mu.RUnlock()
+	// looking up a target outside our zones can take a while, so it happens
+	// without the lock
+	if alias != nil && alias.Resolver != AliasResolverZone {
+		b.resolveAlias(m, q.Qtype, alias)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if alias != nil && alias.Err == nil && len(alias.Records) == 0 {
+		alias.zone.addSOA(m)
+	}
+	if do && m.Rcode != dns.RcodeServerFailure {
+		now := b.now()
+		m.Answer = b.signSection(m.Answer, now)
+		m.Ns = b.signSection(m.Ns, now)
+	}
+	return m, alias
+}
+
+// answer needs to be called with the lock held. If the answer depends on an
+// ALIAS target outside our zones, it returns the ALIAS without looking it up.
+func (b *LocalBackend) answer(m *dns.Msg, q dns.Question, do bool) *AliasResolution {
+	zone := b.findZone(q.Name)
+	if zone == nil {
+		// not one of our zones
+		m.Rcode = dns.RcodeRefused
+		return nil
+	}
+	m.Authoritative = true
+	if q.Qtype == dns.TypeDS && b.answerDS(m, zone, q.Name) {
+		return nil
+	}
+	name := q.Name
+	for i := 0; i < maxCNAMEChain; i++ {
+		if alias, ok := zone.aliasFor(name, q.Qtype); ok {
+			return b.answerAlias(m, zone, name, q.Qtype, alias)
+		}
+		target := zone.resolve(m, name, q.Qtype, b.signingKey(zone.name), do)
+		if target == "" {
+			break
+		}
+		// follow the CNAME if it points at a name we know about
+		zone = b.findZone(target)
+		if zone == nil {
+			break
+		}
+		name = target
+	}
+	return nil
+}
+
+// aliasFor returns name's ALIAS record if it needs one to answer a query
+// for qtype. ALIAS records are only for A and AAAA, and only if the name
+// doesn't have any real records of that type.
+func (zone *localZone) aliasFor(name string, qtype uint16) (localAlias, bool) {
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return localAlias{}, false
+	}
+	lower := strings.ToLower(name)
+	alias, ok := zone.aliases[lower]
+	if !ok || len(zone.rrsOfType(lower, qtype)) > 0 {
+		return localAlias{}, false
+	}
+	return alias, true
+}
+
+// answerAlias answers for name with the ALIAS target's records, if the
+// target is in one of our zones. If it isn't, resolveAlias does it later.
+func (b *LocalBackend) answerAlias(m *dns.Msg, zone *localZone, name string, qtype uint16, alias localAlias) *AliasResolution {
+	resolution := &AliasResolution{
+		Target:   alias.target,
+		Resolver: b.aliasResolver,
+		name:     name,
+		ttl:      alias.ttl,
+		zone:     zone,
+	}
+	if b.findZone(alias.target) == nil {
+		return resolution
+	}
+	resolution.Resolver = AliasResolverZone
+	resolution.Records = b.lookup(alias.target, qtype)
+	m.Answer = append(m.Answer, resolution.flatten()...)
+	return resolution
+}
+
+// lookup finds name's records of type qtype in our zones. It follows CNAMEs,
+// but not ALIAS records, so that ALIASes pointing at each other can't loop.
+func (b *LocalBackend) lookup(name string, qtype uint16) []dns.RR {
+	m := new(dns.Msg)
+	zone := b.findZone(name)
+	for i := 0; zone != nil && i < maxCNAMEChain; i++ {
+		target := zone.resolve(m, name, qtype, nil, false)
+		if target == "" {
+			break
+		}
+		zone = b.findZone(target)
+		name = target
+	}
+	return answersOfType(m.Answer, qtype)
+}
+
+// resolveAlias looks up an ALIAS target that isn't in our zones, and adds
+// the answer to m. If the lookup fails, we can't answer at all, so the
+// response is a SERVFAIL.
+func (b *LocalBackend) resolveAlias(m *dns.Msg, qtype uint16, resolution *AliasResolution) {
+	query := new(dns.Msg)
+	query.SetQuestion(resolution.Target, qtype)
+	client := &dns.Client{Timeout: aliasTimeout}
+	response, _, err := client.Exchange(query, resolution.Resolver)
+	switch {
+	case err != nil:
+		resolution.Err = err
+	// the target not existing is the same as it not having any records
+	case response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError:
+		resolution.Err = fmt.Errorf("%s returned %s", resolution.Resolver, dns.RcodeToString[response.Rcode])
+	}
+	if resolution.Err != nil {
+		m.Rcode = dns.RcodeServerFailure
+		return
+	}
+	resolution.Records = answersOfType(response.Answer, qtype)
+	m.Answer = append(m.Answer, resolution.flatten()...)
+}
+
+// flatten renames the target's records to the name the ALIAS is at. They
+// can't be cached for longer than either the ALIAS or the records.
+func (resolution *AliasResolution) flatten() []dns.RR {
+	rrs := []dns.RR{}
+	for _, rr := range resolution.Records {
+		rr = withName(rr, resolution.name)
+		rr.Header().Ttl = min(rr.Header().Ttl, resolution.ttl)
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func answersOfType(rrs []dns.RR, qtype uint16) []dns.RR {
+	answers := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
+
+// answerDS answers DS queries for the top of a zone from the parent zone's
+// side, like a real parent zone would. It returns false if we don't have
+// the parent zone.
+func (b *LocalBackend) answerDS(m *dns.Msg, zone *localZone, name string) bool {
+	if strings.ToLower(name) != zone.name {
+		return false
+	}
+	next, end := dns.NextLabel(zone.name, 0)
+	if end {
+		return false
+	}
+	parent := b.findZone(zone.name[next:])
+	if parent == nil {
+		return false
+	}
+	if key := b.signingKey(zone.name); key != nil {
+		m.Answer = append(m.Answer, withName(key.ds(), name))
+	} else {
+		parent.addSOA(m)
+	}
+	return true
+}
+
+// findZone finds the most specific zone that name is in
+func (b *LocalBackend) findZone(name string) *localZone {
+	name = strings.ToLower(dns.Fqdn(name))
+	for {
+		if zone, ok := b.zones[name]; ok {
+			return zone
+		}
+		next, end := dns.NextLabel(name, 0)
+		if end {
+			return nil
+		}
+		name = name[next:]
+	}
+}
+
+// resolve adds the answer for name to m. If name is a CNAME (or is under a
+// DNAME), it returns the CNAME's target so that the caller can keep going.
+//
+// key is the zone's DNSSEC key (nil if it isn't signed), and if do is set
+// resolve adds NSEC/NSEC3 records to negative answers. The signatures get
+// added at the end, in Answer.
+func (zone *localZone) resolve(m *dns.Msg, name string, qtype uint16, key *zoneKey, do bool) string {
+	lower := strings.ToLower(name)
+	denial := func(name string) {
+		if key != nil && do {
+			m.Ns = append(m.Ns, zone.denial(name, key)...)
+		}
+	}
+	if cut := zone.delegation(lower); cut != "" {
+		// it's in a subdomain that's been delegated somewhere else
+		if len(m.Answer) == 0 {
+			m.Authoritative = false
+		}
+		m.Ns = append(m.Ns, zone.rrsOfType(cut, dns.TypeNS)...)
+		// prove that there's no DS record
+		denial(cut)
+		return ""
+	}
+	if dname := zone.dname(lower); dname != nil {
+		return zone.synthesize(m, name, dname)
+	}
+	rrs, ok := zone.records[lower]
+	if !ok {
+		if zone.hasDescendants(lower) {
+			// empty non-terminal: the name exists, it just has no records
+			zone.addSOA(m)
+			denial(lower)
+			return ""
+		}
+		wildcard := zone.wildcard(lower)
+		if wildcard == "" {
+			m.Rcode = dns.RcodeNameError
+			zone.addSOA(m)
+			denial(lower)
+			return ""
+		}
+		rrs = zone.records[wildcard]
+	}
+	if lower == zone.name && key != nil {
+		rrs = append(append([]dns.RR{}, rrs...), zone.apexRecords(key)...)
+	}
+	answers := []dns.RR{}
+	for _, rr := range rrs {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			answers = append(answers, withName(rr, name))
+		}
+	}
+	if len(answers) > 0 {
+		m.Answer = append(m.Answer, answers...)
+		return ""
+	}
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			m.Answer = append(m.Answer, withName(rr, name))
+			return cname.Target
+		}
+	}
+	zone.addSOA(m)
+	denial(lower)
+	return ""
+}
+
+// delegation returns the closest name above name (but below the zone apex)
+// that has NS records, if there is one
+func (zone *localZone) delegation(name string) string {
+	for name != zone.name && strings.HasSuffix(name, "."+zone.name) {
+		for _, rr := range zone.records[name] {
+			if rr.Header().Rrtype == dns.TypeNS {
+				return name
+			}
+		}
+		next, _ := dns.NextLabel(name, 0)
+		name = name[next:]
+	}
+	return ""
+}
+
+// dname returns the DNAME record above name, if there is one. A DNAME only
+// redirects the names under it, not the name it's at.
+func (zone *localZone) dname(name string) *dns.DNAME {
+	for name != zone.name {
+		next, end := dns.NextLabel(name, 0)
+		if end {
+			return nil
+		}
+		name = name[next:]
+		for _, rr := range zone.records[name] {
+			if dname, ok := rr.(*dns.DNAME); ok {
+				return dname
+			}
+		}
+	}
+	return nil
+}
+
+// synthesize answers for a name under a DNAME with the DNAME and a CNAME
+// that replaces the DNAME's name with its target, like RFC 6672 says. It
+// returns the CNAME's target.
+func (zone *localZone) synthesize(m *dns.Msg, name string, dname *dns.DNAME) string {
+	m.Answer = append(m.Answer, dns.Copy(dname))
+	prefix := name[:len(name)-len(dname.Hdr.Name)]
+	target := prefix + dname.Target
+	if dname.Target == "." {
+		target = prefix
+	}
+	if _, ok := dns.IsDomainName(target); !ok || len(target) > 255 {
+		// the new name would be too long
+		m.Rcode = dns.RcodeYXDomain
+		return ""
+	}
+	m.Answer = append(m.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: dname.Hdr.Ttl},
+		Target: target,
+	})
+	return target
+}
+
+func (zone *localZone) hasDescendants(name string) bool {
+	for other := range zone.records {
+		if strings.HasSuffix(other, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wildcard is the wildcard record (like "*.orange.messwithdns.com.") that
+// answers queries for name, or "" if name isn't covered by a wildcard
+func (b *LocalBackend) Wildcard(name string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	zone := b.findZone(name)
+	if zone == nil {
+		return ""
+	}
+	lower := strings.ToLower(dns.Fqdn(name))
+	if _, ok := zone.records[lower]; ok || zone.hasDescendants(lower) {
+		return ""
+	}
+	return zone.wildcard(lower)
+}
+
+// wildcard finds the wildcard record that matches name, if there is one
+func (zone *localZone) wildcard(name string) string {
+	return findWildcard(zone.name, name, func(name string) bool {
+		_, ok := zone.records[name]
+		return ok
+	}, zone.hasDescendants)
+}
+
+func (zone *localZone) rrsOfType(name string, qtype uint16) []dns.RR {
+	rrs := []dns.RR{}
+	for _, rr := range zone.records[name] {
+		if rr.Header().Rrtype == qtype {
+			rrs = append(rrs, dns.Copy(rr))
+		}
+	}
+	return rrs
+}
+
+// addSOA adds the SOA record to the authority section for negative answers,
+// with the TTL capped at the SOA minimum like RFC 2308 says
+func (zone *localZone) addSOA(m *dns.Msg) {
+	soa, ok := zone.soa()
+	if !ok {
+		return
+	}
+	rr := dns.Copy(soa).(*dns.SOA)
+	if rr.Minttl < rr.Hdr.Ttl {
+		rr.Hdr.Ttl = rr.Minttl
+	}
+	m.Ns = append(m.Ns, rr)
+}
+
+func withName(rr dns.RR, name string) dns.RR {
+	rr = dns.Copy(rr)
+	rr.Header().Name = name
+	return rr
+}
@@ -0,0 +1,252 @@
+package records_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupAnswer creates a zone with the records that recs returns, and loads
+// messwithdns.com from its zone file
+func setupAnswer(t *testing.T, recs func(username string) []map[string]string) (*records.LocalBackend, string) {
+	rs, backend, ctx, username := setupLocal(t)
+	for _, record := range recs(username) {
+		err := rs.CreateRecord(ctx, username, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := backend.LoadZoneFile("../../pdns/conf_prod/messwithdns.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, username
+}
+
+func query(backend *records.LocalBackend, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	return backend.Answer(m)
+}
+
+func TestAnswer(t *testing.T) {
+	backend, username := setupAnswer(t, func(username string) []map[string]string {
+		return []map[string]string{
+			{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+			{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": domain(username)},
+			{"subdomain": "ext", "type": "CNAME", "ttl": "60", "value_Target": "example.com"},
+			{"subdomain": "a.b", "type": "TXT", "ttl": "60", "value_Txt": "hello world"},
+			{"subdomain": "*.wild", "type": "A", "ttl": "60", "value_A": "5.6.7.8"},
+			{"subdomain": "sub", "type": "NS", "ttl": "60", "value_Ns": "ns1.example.com"},
+		}
+	})
+
+	response := query(backend, domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.True(t, response.Authoritative)
+	assert.Equal(t, "1.2.3.4", response.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, uint32(60), response.Answer[0].Header().Ttl)
+
+	// CNAMEs get followed inside our zones, but not outside
+	response = query(backend, "www."+domain(username), dns.TypeA)
+	assert.Equal(t, 2, len(response.Answer))
+	assert.Equal(t, dns.TypeCNAME, response.Answer[0].Header().Rrtype)
+	assert.Equal(t, "1.2.3.4", response.Answer[1].(*dns.A).A.String())
+	response = query(backend, "ext."+domain(username), dns.TypeA)
+	assert.Equal(t, 1, len(response.Answer))
+
+	// NODATA
+	response = query(backend, domain(username), dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, 0, len(response.Answer))
+	assert.Equal(t, dns.TypeSOA, response.Ns[0].Header().Rrtype)
+
+	// empty non-terminal
+	response = query(backend, "b."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+
+	// NXDOMAIN
+	response = query(backend, "nope."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.Equal(t, uint32(3600), response.Ns[0].Header().Ttl)
+
+	// wildcards
+	response = query(backend, "anything.wild."+domain(username), dns.TypeA)
+	assert.Equal(t, "anything.wild."+domain(username), response.Answer[0].Header().Name)
+	assert.Equal(t, "5.6.7.8", response.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, "*.wild."+domain(username), backend.Wildcard("anything.wild."+domain(username)))
+	assert.Equal(t, "", backend.Wildcard("a.b."+domain(username)))
+
+	// delegations
+	response = query(backend, "www.sub."+domain(username), dns.TypeA)
+	assert.False(t, response.Authoritative)
+	assert.Equal(t, 0, len(response.Answer))
+	assert.Equal(t, "ns1.example.com.", response.Ns[0].(*dns.NS).Ns)
+}
+
+func TestAnswerStaticZone(t *testing.T) {
+	backend, _ := setupAnswer(t, func(string) []map[string]string { return nil })
+
+	response := query(backend, "orange.messwithdns.com.", dns.TypeA)
+	assert.Equal(t, "213.188.218.160", response.Answer[0].(*dns.A).A.String())
+
+	response = query(backend, "example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeRefused, response.Rcode)
+
+	err := backend.DeleteZone(context.Background(), "messwithdns.com.")
+	assert.NotNil(t, err)
+}
+
+func TestAnswerCase(t *testing.T) {
+	backend, username := setupAnswer(t, func(string) []map[string]string {
+		return []map[string]string{{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}}
+	})
+	m := new(dns.Msg)
+	m.SetQuestion("ORANGE."+domain(username), dns.TypeA)
+	m.SetEdns0(4096, false)
+	response := backend.Answer(m)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.NotNil(t, response.IsEdns0())
+
+	// names are case insensitive
+	m.SetQuestion(strings.ToUpper(domain(username)), dns.TypeA)
+	response = backend.Answer(m)
+	assert.Equal(t, 1, len(response.Answer))
+	assert.Equal(t, strings.ToUpper(domain(username)), response.Answer[0].Header().Name)
+}
+
+func TestAnswerDNAME(t *testing.T) {
+	backend, username := setupAnswer(t, func(username string) []map[string]string {
+		return []map[string]string{
+			{"subdomain": "old", "type": "DNAME", "ttl": "60", "value_Target": "new." + domain(username)},
+			{"subdomain": "www.new", "type": "A", "ttl": "300", "value_A": "1.2.3.4"},
+			{"subdomain": "ext", "type": "DNAME", "ttl": "60", "value_Target": "example.com"},
+		}
+	})
+
+	// the DNAME, a CNAME made from it, and then the target's records
+	response := query(backend, "WWW.old."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, 3, len(response.Answer))
+	assert.Equal(t, "new."+domain(username), response.Answer[0].(*dns.DNAME).Target)
+	cname := response.Answer[1].(*dns.CNAME)
+	assert.Equal(t, "WWW.old."+domain(username), cname.Hdr.Name)
+	assert.Equal(t, "WWW.new."+domain(username), cname.Target)
+	assert.Equal(t, uint32(60), cname.Hdr.Ttl)
+	assert.Equal(t, "1.2.3.4", response.Answer[2].(*dns.A).A.String())
+
+	response = query(backend, "a.b.ext."+domain(username), dns.TypeA)
+	assert.Equal(t, 2, len(response.Answer))
+	assert.Equal(t, "a.b.example.com.", response.Answer[1].(*dns.CNAME).Target)
+
+	// the DNAME's own name doesn't get redirected
+	response = query(backend, "old."+domain(username), dns.TypeA)
+	assert.Equal(t, 0, len(response.Answer))
+	response = query(backend, "old."+domain(username), dns.TypeDNAME)
+	assert.Equal(t, 1, len(response.Answer))
+
+	// the target doesn't exist
+	response = query(backend, "nope.old."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.Equal(t, 2, len(response.Answer))
+}
+
+// testResolver answers every A query for example.github.io with one record,
+// and SERVFAILs everything else
+func testResolver(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name == "example.github.io." && q.Qtype == dns.TypeA:
+			rr, _ := dns.NewRR("example.github.io. 3600 IN A 185.199.108.153")
+			m.Answer = append(m.Answer, rr)
+		case q.Name == "example.github.io.":
+		default:
+			m.Rcode = dns.RcodeServerFailure
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestAnswerAlias(t *testing.T) {
+	backend, username := setupAnswer(t, func(username string) []map[string]string {
+		return []map[string]string{
+			{"subdomain": "@", "type": "ALIAS", "ttl": "60", "value_Target": "example.github.io"},
+			{"subdomain": "@", "type": "MX", "ttl": "60", "value_Preference": "10", "value_Mx": "mail.example.com"},
+			{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": domain(username)},
+		}
+	})
+	backend.SetAliasResolver(testResolver(t))
+
+	// the target's records, renamed, with the ALIAS's TTL since it's lower
+	m := new(dns.Msg)
+	m.SetQuestion(domain(username), dns.TypeA)
+	response, alias := backend.AnswerQuery(m)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, 1, len(response.Answer))
+	assert.Equal(t, domain(username), response.Answer[0].Header().Name)
+	assert.Equal(t, uint32(60), response.Answer[0].Header().Ttl)
+	assert.Equal(t, "185.199.108.153", response.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, "example.github.io.", alias.Target)
+	assert.Equal(t, "example.github.io.", alias.Records[0].Header().Name)
+	assert.Nil(t, alias.Err)
+
+	// the other records are still there
+	response = query(backend, domain(username), dns.TypeMX)
+	assert.Equal(t, 1, len(response.Answer))
+
+	// CNAMEs to an ALIAS work
+	response = query(backend, "www."+domain(username), dns.TypeA)
+	assert.Equal(t, 2, len(response.Answer))
+	assert.Equal(t, "185.199.108.153", response.Answer[1].(*dns.A).A.String())
+
+	// no AAAA records
+	response = query(backend, domain(username), dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, 0, len(response.Answer))
+	assert.Equal(t, dns.TypeSOA, response.Ns[0].Header().Rrtype)
+
+	// if the resolver doesn't work, we can't answer
+	backend.SetAliasResolver("127.0.0.1:1")
+	m.SetQuestion(domain(username), dns.TypeA)
+	response, alias = backend.AnswerQuery(m)
+	assert.Equal(t, dns.RcodeServerFailure, response.Rcode)
+	assert.NotNil(t, alias.Err)
+}
+
+func TestAnswerAliasInZone(t *testing.T) {
+	backend, username := setupAnswer(t, func(username string) []map[string]string {
+		return []map[string]string{
+			{"subdomain": "@", "type": "ALIAS", "ttl": "600", "value_Target": "orange.messwithdns.com"},
+			{"subdomain": "@", "type": "AAAA", "ttl": "60", "value_AAAA": "2001:4860:4860::8888"},
+		}
+	})
+
+	// targets in our zones don't need a resolver
+	m := new(dns.Msg)
+	m.SetQuestion(domain(username), dns.TypeA)
+	response, alias := backend.AnswerQuery(m)
+	assert.Equal(t, records.AliasResolverZone, alias.Resolver)
+	assert.Equal(t, "213.188.218.160", response.Answer[0].(*dns.A).A.String())
+	assert.Equal(t, uint32(600), response.Answer[0].Header().Ttl)
+
+	// real records win
+	m.SetQuestion(domain(username), dns.TypeAAAA)
+	response, alias = backend.AnswerQuery(m)
+	assert.Nil(t, alias)
+	assert.Equal(t, "2001:4860:4860::8888", response.Answer[0].(*dns.AAAA).AAAA.String())
+}
@@ -0,0 +1,61 @@
+package records
+
+import (
+	"context"
+	"errors"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+)
+
+// Backend is where the zones actually live. RecordService does all the
+// editing, the backend just needs to store zones and apply changes to them.
+type Backend interface {
+	GetZone(ctx context.Context, name string) (*powerdns.Zone, error)
+	AddZone(ctx context.Context, zone *powerdns.Zone) error
+	// PatchRRsets works like PowerDNS's PATCH: REPLACE replaces every record
+	// for that name/type (an empty REPLACE deletes them), DELETE deletes them
+	PatchRRsets(ctx context.Context, name string, rrsets []powerdns.RRset) error
+	DeleteZone(ctx context.Context, name string) error
+	ListZones(ctx context.Context) ([]string, error)
+}
+
+var ErrZoneNotFound = errors.New("zone not found")
+
+// PowerDNSBackend stores zones in PowerDNS, through its HTTP API
+type PowerDNSBackend struct {
+	pdns *powerdns.Client
+}
+
+func NewPowerDNSBackend(url string, api_key string) *PowerDNSBackend {
+	pdns := powerdns.NewClient(url, "localhost", map[string]string{"X-API-Key": api_key}, nil)
+	return &PowerDNSBackend{pdns: pdns}
+}
+
+func (b *PowerDNSBackend) GetZone(ctx context.Context, name string) (*powerdns.Zone, error) {
+	return b.pdns.Zones.Get(ctx, name)
+}
+
+func (b *PowerDNSBackend) AddZone(ctx context.Context, zone *powerdns.Zone) error {
+	_, err := b.pdns.Zones.Add(ctx, zone)
+	return err
+}
+
+func (b *PowerDNSBackend) PatchRRsets(ctx context.Context, name string, rrsets []powerdns.RRset) error {
+	return b.pdns.Records.Patch(ctx, name, &powerdns.RRsets{Sets: rrsets})
+}
+
+func (b *PowerDNSBackend) DeleteZone(ctx context.Context, name string) error {
+	return b.pdns.Zones.Delete(ctx, name)
+}
+
+func (b *PowerDNSBackend) ListZones(ctx context.Context) ([]string, error) {
+	zones, err := b.pdns.Zones.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, zone := range zones {
+		names = append(names, *zone.Name)
+	}
+	return names, nil
+}
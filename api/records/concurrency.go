@@ -0,0 +1,114 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+)
+
+// zoneLocks makes sure only one request at a time edits a zone. Edits get
+// the zone, change it, and then PATCH it, so two at once could clobber each
+// other.
+type zoneLocks struct {
+	mu    sync.Mutex
+	locks map[string]*zoneLock
+}
+
+type zoneLock struct {
+	sync.Mutex
+	// how many requests are using or waiting for this lock, so we know when
+	// we can forget about it
+	refs int
+}
+
+func newZoneLocks() *zoneLocks {
+	return &zoneLocks{locks: map[string]*zoneLock{}}
+}
+
+// lock locks the zone and returns a function that unlocks it
+func (l *zoneLocks) lock(name string) func() {
+	l.mu.Lock()
+	zl, ok := l.locks[name]
+	if !ok {
+		zl = &zoneLock{}
+		l.locks[name] = zl
+	}
+	zl.refs++
+	l.mu.Unlock()
+
+	zl.Lock()
+	return func() {
+		zl.Unlock()
+		l.mu.Lock()
+		zl.refs--
+		if zl.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (rs RecordService) lockZone(username string) func() {
+	return rs.locks.lock(zoneName(username))
+}
+
+type ifMatchKey struct{}
+
+// IfMatch makes edits with this context fail with a 409 unless the zone's
+// SOA serial is still one of serials. This is how we stop two browser tabs
+// from overwriting each other's changes.
+func IfMatch(ctx context.Context, serials ...uint32) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, serials)
+}
+
+// zoneSerial is the serial from the zone's SOA record, or 0 if it doesn't
+// have one yet
+func zoneSerial(zone *powerdns.Zone) uint32 {
+	for _, rrset := range zone.RRsets {
+		if *rrset.Type != powerdns.RRTypeSOA || len(rrset.Records) == 0 {
+			continue
+		}
+		fields := strings.Fields(*rrset.Records[0].Content)
+		if len(fields) != 7 {
+			return 0
+		}
+		serial, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0
+		}
+		return uint32(serial)
+	}
+	return 0
+}
+
+func checkSerial(ctx context.Context, zone *powerdns.Zone) *HTTPError {
+	expected, ok := ctx.Value(ifMatchKey{}).([]uint32)
+	if !ok {
+		return nil
+	}
+	serial := zoneSerial(zone)
+	for _, s := range expected {
+		if s == serial {
+			return nil
+		}
+	}
+	return newHTTPError(http.StatusConflict, fmt.Errorf("Error: your records were changed somewhere else (maybe in another tab) since you loaded them"))
+}
+
+// getZoneForEdit gets the zone and checks that it hasn't changed since the
+// client last saw it. The zone needs to be locked.
+func (rs RecordService) getZoneForEdit(ctx context.Context, username string) (*powerdns.Zone, *HTTPError) {
+	zone, err := rs.getOrCreateZone(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	if httpErr := checkSerial(ctx, zone); httpErr != nil {
+		return nil, httpErr
+	}
+	return zone, nil
+}
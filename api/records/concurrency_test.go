@@ -0,0 +1,92 @@
+package records_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatch(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	record := map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}
+	err := rs.CreateRecord(ctx, username, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serial, err := rs.GetRecordsAndSerial(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record = map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "5.6.7.8"}
+	err = rs.CreateRecord(records.IfMatch(ctx, serial), username, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the serial changed, so using the old one again is a conflict
+	record = map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "9.9.9.9"}
+	err = rs.CreateRecord(records.IfMatch(ctx, serial), username, record)
+	assert.Equal(t, 409, err.Code)
+	err = rs.DeleteAllRecords(records.IfMatch(ctx, serial), username)
+	assert.Equal(t, 409, err.Code)
+	// it's fine as long as one of the serials is the current one
+	record = map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "9.9.9.9"}
+	err = rs.CreateRecord(records.IfMatch(ctx, serial, serial+1), username, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, newSerial, err := rs.GetRecordsAndSerial(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(recs))
+	assert.Equal(t, serial+2, newSerial)
+}
+
+// slowBackend makes edits take long enough that they'd overlap if they
+// weren't locked
+type slowBackend struct {
+	*records.LocalBackend
+}
+
+func (b slowBackend) GetZone(ctx context.Context, name string) (*powerdns.Zone, error) {
+	zone, err := b.LocalBackend.GetZone(ctx, name)
+	time.Sleep(time.Millisecond)
+	return zone, err
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	_, backend, ctx, username := setupLocal(t)
+	rs := records.NewRecordService(slowBackend{backend})
+	// make the zone first, so that the goroutines don't race to create it
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "TXT", "ttl": "60", "value_Txt": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record := map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": fmt.Sprintf("1.2.3.%d", i)}
+			if err := rs.CreateRecord(ctx, username, record); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SOA + TXT + all the A records: none of them got lost
+	assert.Equal(t, 22, len(recs))
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS zones (
+    name TEXT PRIMARY KEY,
+    rrsets TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS dnssec_keys (
+    zone TEXT PRIMARY KEY,
+    settings TEXT NOT NULL,
+    dnskey TEXT NOT NULL,
+    private_key TEXT NOT NULL
+);
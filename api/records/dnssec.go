@@ -0,0 +1,544 @@
+package records
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/miekg/dns"
+)
+
+// DNSSECSettings is what users can change about how their zone gets signed.
+// Break deliberately breaks the signatures in one of a few ways, so that you
+// can see what validating resolvers do about it.
+type DNSSECSettings struct {
+	Enabled bool   `json:"enabled"`
+	NSEC3   bool   `json:"nsec3"`
+	Break   string `json:"break"`
+}
+
+const (
+	BreakNone = ""
+	// RRSIGs that expired a week ago
+	BreakExpiredRRSIG = "expired-rrsig"
+	// a DS record that doesn't match the DNSKEY
+	BreakWrongDS = "wrong-ds"
+)
+
+type DNSSECStatus struct {
+	DNSSECSettings
+	// the records you'd need to give the parent zone, empty if DNSSEC is off
+	DNSKEY string `json:"dnskey,omitempty"`
+	DS     string `json:"ds,omitempty"`
+}
+
+// DNSSECBackend is implemented by backends that can sign zones. The local
+// backend signs answers as it sends them, with keys it keeps itself, and
+// PowerDNS does its own signing once we turn it on through its API.
+type DNSSECBackend interface {
+	GetDNSSEC(ctx context.Context, zone string) (*DNSSECStatus, error)
+	SetDNSSEC(ctx context.Context, zone string, settings DNSSECSettings) error
+}
+
+// ErrBreakNotSupported is for backends that can sign zones, but can't break
+// the signatures on purpose
+var ErrBreakNotSupported = errors.New("Error: breaking DNSSEC on purpose isn't supported on this server")
+
+type zoneKey struct {
+	settings DNSSECSettings
+	dnskey   *dns.DNSKEY
+	private  crypto.Signer
+}
+
+func (rs RecordService) GetDNSSEC(ctx context.Context, username string) (*DNSSECStatus, *HTTPError) {
+	backend, ok := rs.backend.(DNSSECBackend)
+	if !ok {
+		return nil, newHTTPError(http.StatusNotImplemented, fmt.Errorf("DNSSEC isn't supported on this server"))
+	}
+	status, err := backend.GetDNSSEC(ctx, zoneName(username))
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	return status, nil
+}
+
+func (rs RecordService) SetDNSSEC(ctx context.Context, username string, settings DNSSECSettings) *HTTPError {
+	backend, ok := rs.backend.(DNSSECBackend)
+	if !ok {
+		return newHTTPError(http.StatusNotImplemented, fmt.Errorf("DNSSEC isn't supported on this server"))
+	}
+	if settings.Break != BreakNone && settings.Break != BreakExpiredRRSIG && settings.Break != BreakWrongDS {
+		return newHTTPError(http.StatusBadRequest, fmt.Errorf("Error: unknown way to break DNSSEC: %s", settings.Break))
+	}
+	unlock := rs.lockZone(username)
+	defer unlock()
+	_, err := rs.getOrCreateZone(ctx, username)
+	if err != nil {
+		return newHTTPError(http.StatusInternalServerError, err)
+	}
+	err = backend.SetDNSSEC(ctx, zoneName(username), settings)
+	if errors.Is(err, ErrBreakNotSupported) {
+		return newHTTPError(http.StatusNotImplemented, err)
+	}
+	if err != nil {
+		return newHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+// dnssecRecords returns the DNSKEY and DS records for the zone as RRsets, so
+// that GetRecords can show them
+func (rs RecordService) dnssecRecords(ctx context.Context, name string) ([]powerdns.RRset, error) {
+	backend, ok := rs.backend.(DNSSECBackend)
+	if !ok {
+		return nil, nil
+	}
+	status, err := backend.GetDNSSEC(ctx, name)
+	if err != nil || !status.Enabled {
+		return nil, err
+	}
+	rrsets := []powerdns.RRset{}
+	for _, record := range []string{status.DNSKEY, status.DS} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, err
+		}
+		typ := powerdns.RRType(dns.TypeToString[rr.Header().Rrtype])
+		rrsets = addToRRsets(rrsets, name, typ, rr.Header().Ttl, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return rrsets, nil
+}
+
+func (b *LocalBackend) GetDNSSEC(ctx context.Context, zone string) (*DNSSECStatus, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	key, ok := b.keys[strings.ToLower(zone)]
+	if !ok {
+		return &DNSSECStatus{}, nil
+	}
+	status := &DNSSECStatus{DNSSECSettings: key.settings}
+	if key.settings.Enabled {
+		status.DNSKEY = key.dnskey.String()
+		status.DS = key.ds().String()
+	}
+	return status, nil
+}
+
+// SetDNSSEC turns signing on or off for a zone. The key gets created the
+// first time and then kept, so that turning DNSSEC off and on again doesn't
+// change the DS record.
+func (b *LocalBackend) SetDNSSEC(ctx context.Context, zone string, settings DNSSECSettings) error {
+	zone = strings.ToLower(zone)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	z, ok := b.zones[zone]
+	if !ok {
+		return ErrZoneNotFound
+	}
+	if z.static {
+		return fmt.Errorf("zone %s can't be edited", zone)
+	}
+	key, ok := b.keys[zone]
+	if !ok {
+		var err error
+		key, err = newZoneKey(zone)
+		if err != nil {
+			return err
+		}
+	}
+	updated := *key
+	updated.settings = settings
+	if b.db != nil {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		_, err = b.db.Exec("INSERT OR REPLACE INTO dnssec_keys (zone, settings, dnskey, private_key) VALUES (?, ?, ?, ?)",
+			zone, string(data), key.dnskey.String(), key.dnskey.PrivateKeyString(key.private))
+		if err != nil {
+			return err
+		}
+	}
+	b.keys[zone] = &updated
+	return nil
+}
+
+// nsec3Param is the NSEC3PARAM we ask PowerDNS to use: SHA-1 with no extra
+// iterations and no salt, like RFC 9276 says and like the local backend does
+const nsec3Param = "1 0 0 -"
+
+// GetDNSSEC asks PowerDNS whether the zone is signed, and for the key it's
+// signed with
+func (b *PowerDNSBackend) GetDNSSEC(ctx context.Context, zone string) (*DNSSECStatus, error) {
+	z, err := b.pdns.Zones.Get(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	status := &DNSSECStatus{}
+	if z.DNSsec == nil || !*z.DNSsec {
+		return status, nil
+	}
+	status.Enabled = true
+	status.NSEC3 = z.Nsec3Param != nil && *z.Nsec3Param != ""
+	keys, err := b.pdns.Cryptokeys.List(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Active == nil || !*key.Active || key.DNSkey == nil {
+			continue
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s 3600 IN DNSKEY %s", zone, *key.DNSkey))
+		if err != nil {
+			return nil, err
+		}
+		dnskey, ok := rr.(*dns.DNSKEY)
+		// the DS is for the key-signing key
+		if !ok || dnskey.Flags&dns.SEP == 0 {
+			continue
+		}
+		status.DNSKEY = dnskey.String()
+		status.DS = dnskey.ToDS(dns.SHA256).String()
+		break
+	}
+	return status, nil
+}
+
+// SetDNSSEC turns signing on or off for a zone. PowerDNS creates the keys
+// when we turn it on (with the default-ksk-algorithm from pdns.conf), and
+// deletes them when we turn it off, so unlike with the local backend the DS
+// record changes every time.
+func (b *PowerDNSBackend) SetDNSSEC(ctx context.Context, zone string, settings DNSSECSettings) error {
+	if settings.Break != BreakNone {
+		return ErrBreakNotSupported
+	}
+	change := &powerdns.Zone{DNSsec: powerdns.Bool(settings.Enabled)}
+	if settings.Enabled {
+		// an empty NSEC3PARAM switches back to NSEC
+		change.Nsec3Param = powerdns.String("")
+		if settings.NSEC3 {
+			change.Nsec3Param = powerdns.String(nsec3Param)
+		}
+	}
+	return b.pdns.Zones.Change(ctx, zone, change)
+}
+
+func newZoneKey(zone string) (*zoneKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := dnskey.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+	return &zoneKey{dnskey: dnskey, private: private.(*ecdsa.PrivateKey)}, nil
+}
+
+func loadZoneKey(settings string, dnskey string, private string) (*zoneKey, error) {
+	key := &zoneKey{}
+	err := json.Unmarshal([]byte(settings), &key.settings)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := dns.NewRR(dnskey)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	key.dnskey, ok = rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("not a DNSKEY: %s", dnskey)
+	}
+	privateKey, err := key.dnskey.NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	key.private, ok = privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("can't sign with key for %s", key.dnskey.Hdr.Name)
+	}
+	return key, nil
+}
+
+func (key *zoneKey) ds() *dns.DS {
+	ds := key.dnskey.ToDS(dns.SHA256)
+	if key.settings.Break == BreakWrongDS {
+		// change the last digit of the digest
+		last := ds.Digest[len(ds.Digest)-1:]
+		replacement := "0"
+		if last == "0" {
+			replacement = "1"
+		}
+		ds.Digest = ds.Digest[:len(ds.Digest)-1] + replacement
+	}
+	return ds
+}
+
+// signingKey returns the key for zone if DNSSEC is on for it. It needs to be
+// called with the lock held.
+func (b *LocalBackend) signingKey(zone string) *zoneKey {
+	key, ok := b.keys[zone]
+	if !ok || !key.settings.Enabled {
+		return nil
+	}
+	return key
+}
+
+func (key *zoneKey) sign(rrset []dns.RR, now time.Time) (*dns.RRSIG, error) {
+	inception, expiration := now.Add(-time.Hour), now.Add(7*24*time.Hour)
+	if key.settings.Break == BreakExpiredRRSIG {
+		inception, expiration = now.Add(-14*24*time.Hour), now.Add(-7*24*time.Hour)
+	}
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.dnskey.Algorithm,
+		KeyTag:     key.dnskey.KeyTag(),
+		SignerName: key.dnskey.Hdr.Name,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	err := sig.Sign(key.private, rrset)
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// signSection adds RRSIGs for every RRset in the section that's in a signed
+// zone. It needs to be called with the lock held.
+func (b *LocalBackend) signSection(rrs []dns.RR, now time.Time) []dns.RR {
+	type rrsetKey struct {
+		name string
+		typ  uint16
+	}
+	rrsets := map[rrsetKey][]dns.RR{}
+	order := []rrsetKey{}
+	for _, rr := range rrs {
+		k := rrsetKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		if _, ok := rrsets[k]; !ok {
+			order = append(order, k)
+		}
+		rrsets[k] = append(rrsets[k], rr)
+	}
+	for _, k := range order {
+		// DS records belong to the parent zone
+		if k.typ == dns.TypeRRSIG || k.typ == dns.TypeDS {
+			continue
+		}
+		zone := b.findZone(k.name)
+		if zone == nil {
+			continue
+		}
+		// NS records at a delegation aren't signed, the child zone has
+		// the real ones
+		if k.typ == dns.TypeNS && k.name != zone.name {
+			continue
+		}
+		key := b.signingKey(zone.name)
+		if key == nil {
+			continue
+		}
+		sig, err := key.sign(rrsets[k], now)
+		if err != nil {
+			// an unsigned answer is the best we can do
+			fmt.Println("error signing", k.name, err)
+			continue
+		}
+		rrs = append(rrs, sig)
+	}
+	return rrs
+}
+
+// typesAt is the type bitmap for a NSEC or NSEC3 record at name
+func (zone *localZone) typesAt(name string, key *zoneKey) []uint16 {
+	types := map[uint16]bool{}
+	for _, rr := range zone.records[name] {
+		types[rr.Header().Rrtype] = true
+	}
+	if name == zone.name {
+		types[dns.TypeDNSKEY] = true
+		if key.settings.NSEC3 {
+			types[dns.TypeNSEC3PARAM] = true
+		}
+	}
+	if len(types) > 0 && !(types[dns.TypeNS] && name != zone.name) {
+		types[dns.TypeRRSIG] = true
+	}
+	if !key.settings.NSEC3 {
+		types[dns.TypeNSEC] = true
+		types[dns.TypeRRSIG] = true
+	}
+	bitmap := []uint16{}
+	for typ := range types {
+		bitmap = append(bitmap, typ)
+	}
+	sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+	return bitmap
+}
+
+// closestEncloser is the closest name above name that exists in the zone
+// (possibly as an empty non-terminal)
+func (zone *localZone) closestEncloser(name string) string {
+	for name != zone.name {
+		if _, ok := zone.records[name]; ok || zone.hasDescendants(name) {
+			return name
+		}
+		next, _ := dns.NextLabel(name, 0)
+		name = name[next:]
+	}
+	return name
+}
+
+// denial returns the NSEC or NSEC3 records that prove that name doesn't
+// exist (or doesn't have the type that was asked for, if it does exist).
+// The caller signs them along with the rest of the response.
+func (zone *localZone) denial(name string, key *zoneKey) []dns.RR {
+	if key.settings.NSEC3 {
+		return zone.nsec3Denial(name, key)
+	}
+	return zone.nsecDenial(name, key)
+}
+
+func (zone *localZone) ttl() uint32 {
+	soa, ok := zone.soa()
+	if !ok {
+		return 3600
+	}
+	return min(soa.Hdr.Ttl, soa.Minttl)
+}
+
+func (zone *localZone) nsecDenial(name string, key *zoneKey) []dns.RR {
+	names := []string{}
+	for owner := range zone.records {
+		names = append(names, owner)
+	}
+	if _, ok := zone.records[zone.name]; !ok {
+		names = append(names, zone.name)
+	}
+	sort.Slice(names, func(i, j int) bool { return canonicalLess(names[i], names[j]) })
+
+	nsecFor := func(target string) dns.RR {
+		// the NSEC for target is the one at the last name <= target
+		i := sort.Search(len(names), func(i int) bool { return canonicalLess(target, names[i]) }) - 1
+		if i < 0 {
+			i = len(names) - 1
+		}
+		return &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: names[i], Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: zone.ttl()},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: zone.typesAt(names[i], key),
+		}
+	}
+
+	proof := []dns.RR{nsecFor(name)}
+	if _, ok := zone.records[name]; ok || zone.hasDescendants(name) {
+		return proof
+	}
+	// also prove there's no wildcard that could have matched
+	wildcard := nsecFor("*." + zone.closestEncloser(name))
+	if wildcard.Header().Name != proof[0].Header().Name {
+		proof = append(proof, wildcard)
+	}
+	return proof
+}
+
+func (zone *localZone) nsec3Denial(name string, key *zoneKey) []dns.RR {
+	// every name in the zone needs an NSEC3 record, including empty
+	// non-terminals
+	exists := map[string]bool{zone.name: true}
+	for owner := range zone.records {
+		for owner != zone.name && strings.HasSuffix(owner, "."+zone.name) {
+			exists[owner] = true
+			next, _ := dns.NextLabel(owner, 0)
+			owner = owner[next:]
+		}
+	}
+	type hashed struct {
+		hash string
+		name string
+	}
+	hashes := []hashed{}
+	for owner := range exists {
+		hashes = append(hashes, hashed{dns.HashName(owner, dns.SHA1, 0, ""), owner})
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].hash < hashes[j].hash })
+
+	nsec3For := func(target string) dns.RR {
+		hash := dns.HashName(target, dns.SHA1, 0, "")
+		// the NSEC3 that matches or covers target is the one at the last
+		// hash <= target's hash
+		i := sort.Search(len(hashes), func(i int) bool { return hashes[i].hash > hash }) - 1
+		if i < 0 {
+			i = len(hashes) - 1
+		}
+		return &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(hashes[i].hash) + "." + zone.name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: zone.ttl()},
+			Hash:       dns.SHA1,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)].hash,
+			TypeBitMap: zone.typesAt(hashes[i].name, key),
+		}
+	}
+
+	if exists[name] {
+		return []dns.RR{nsec3For(name)}
+	}
+	// closest encloser proof (RFC 5155 section 7.2.1), plus no wildcard
+	encloser := zone.closestEncloser(name)
+	nextCloser := name
+	for {
+		next, _ := dns.NextLabel(nextCloser, 0)
+		if nextCloser[next:] == encloser {
+			break
+		}
+		nextCloser = nextCloser[next:]
+	}
+	proof := []dns.RR{}
+	seen := map[string]bool{}
+	for _, target := range []string{encloser, nextCloser, "*." + encloser} {
+		rr := nsec3For(target)
+		if !seen[rr.Header().Name] {
+			seen[rr.Header().Name] = true
+			proof = append(proof, rr)
+		}
+	}
+	return proof
+}
+
+// canonicalLess compares names in DNSSEC canonical order (RFC 4034 section
+// 6.1): label by label, starting from the right
+func canonicalLess(a string, b string) bool {
+	al := dns.SplitDomainName(strings.ToLower(a))
+	bl := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(al) && i <= len(bl); i++ {
+		x, y := al[len(al)-i], bl[len(bl)-i]
+		if x != y {
+			return x < y
+		}
+	}
+	return len(al) < len(bl)
+}
+
+// apexRecords are the DNSSEC records at the top of a signed zone, which
+// aren't stored with the rest of the records
+func (zone *localZone) apexRecords(key *zoneKey) []dns.RR {
+	rrs := []dns.RR{dns.Copy(key.dnskey)}
+	if key.settings.NSEC3 {
+		rrs = append(rrs, &dns.NSEC3PARAM{
+			Hdr:  dns.RR_Header{Name: zone.name, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET, Ttl: 0},
+			Hash: dns.SHA1,
+		})
+	}
+	return rrs
+}
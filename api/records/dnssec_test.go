@@ -0,0 +1,408 @@
+package records_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDNSSEC(t *testing.T, settings records.DNSSECSettings) (*records.LocalBackend, string) {
+	backend, username := setupAnswer(t, func(username string) []map[string]string {
+		return []map[string]string{
+			{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+			{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": domain(username)},
+			{"subdomain": "a.b", "type": "TXT", "ttl": "60", "value_Txt": "hello world"},
+			{"subdomain": "sub", "type": "NS", "ttl": "60", "value_Ns": "ns1.example.com"},
+		}
+	})
+	rs := records.NewRecordService(backend)
+	if err := rs.SetDNSSEC(context.Background(), username, settings); err != nil {
+		t.Fatal(err)
+	}
+	return backend, username
+}
+
+func queryDO(backend *records.LocalBackend, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(1232, true)
+	return backend.Answer(m)
+}
+
+func getDNSKEY(t *testing.T, backend *records.LocalBackend, username string) *dns.DNSKEY {
+	response := queryDO(backend, domain(username), dns.TypeDNSKEY)
+	for _, rr := range response.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			return key
+		}
+	}
+	t.Fatal("no DNSKEY")
+	return nil
+}
+
+// verifySection checks every RRSIG in the section against the RRset it
+// covers, and returns how many there were
+func verifySection(t *testing.T, rrs []dns.RR, key *dns.DNSKEY) int {
+	count := 0
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		rrset := []dns.RR{}
+		for _, other := range rrs {
+			if strings.EqualFold(other.Header().Name, sig.Hdr.Name) && other.Header().Rrtype == sig.TypeCovered {
+				rrset = append(rrset, other)
+			}
+		}
+		assert.NoError(t, sig.Verify(key, rrset), "RRSIG for %s %s", sig.Hdr.Name, dns.TypeToString[sig.TypeCovered])
+		count++
+	}
+	return count
+}
+
+func hasType(bitmap []uint16, typ uint16) bool {
+	for _, t := range bitmap {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDNSSECSigning(t *testing.T) {
+	backend, username := setupDNSSEC(t, records.DNSSECSettings{Enabled: true})
+	key := getDNSKEY(t, backend, username)
+	assert.Equal(t, uint16(dns.ECDSAP256SHA256), uint16(key.Algorithm))
+
+	response := queryDO(backend, "www."+domain(username), dns.TypeA)
+	assert.Equal(t, 4, len(response.Answer))
+	assert.Equal(t, 2, verifySection(t, response.Answer, key))
+	for _, rr := range response.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			assert.True(t, sig.ValidityPeriod(time.Now()))
+		}
+	}
+
+	// the DNSKEY is signed by itself
+	response = queryDO(backend, domain(username), dns.TypeDNSKEY)
+	assert.Equal(t, 1, verifySection(t, response.Answer, key))
+
+	// the DS comes from the parent zone, unsigned
+	response = queryDO(backend, domain(username), dns.TypeDS)
+	assert.Equal(t, 1, len(response.Answer))
+	ds := response.Answer[0].(*dns.DS)
+	assert.Equal(t, key.ToDS(dns.SHA256).Digest, ds.Digest)
+
+	// no signatures if the client doesn't ask for them
+	response = query(backend, domain(username), dns.TypeA)
+	assert.Equal(t, 1, len(response.Answer))
+}
+
+func TestDNSSECNSEC(t *testing.T) {
+	backend, username := setupDNSSEC(t, records.DNSSECSettings{Enabled: true})
+	key := getDNSKEY(t, backend, username)
+
+	// NXDOMAIN: an NSEC that covers the name, and one that covers the wildcard
+	response := queryDO(backend, "nope."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	nsecs := 0
+	for _, rr := range response.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			nsecs++
+			assert.True(t, strings.HasSuffix(nsec.Hdr.Name, domain(username)))
+		}
+	}
+	assert.Equal(t, 2, nsecs)
+	assert.Equal(t, 3, verifySection(t, response.Ns, key))
+
+	// NODATA: the NSEC at the name, without the type
+	response = queryDO(backend, domain(username), dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	var nsec *dns.NSEC
+	for _, rr := range response.Ns {
+		if n, ok := rr.(*dns.NSEC); ok {
+			nsec = n
+		}
+	}
+	assert.Equal(t, domain(username), nsec.Hdr.Name)
+	assert.True(t, hasType(nsec.TypeBitMap, dns.TypeA))
+	assert.True(t, hasType(nsec.TypeBitMap, dns.TypeDNSKEY))
+	assert.False(t, hasType(nsec.TypeBitMap, dns.TypeAAAA))
+	assert.Equal(t, 2, verifySection(t, response.Ns, key))
+
+	// delegations: prove there's no DS
+	response = queryDO(backend, "www.sub."+domain(username), dns.TypeA)
+	assert.False(t, response.Authoritative)
+	nsec = nil
+	for _, rr := range response.Ns {
+		if n, ok := rr.(*dns.NSEC); ok {
+			nsec = n
+		}
+	}
+	assert.Equal(t, "sub."+domain(username), nsec.Hdr.Name)
+	assert.True(t, hasType(nsec.TypeBitMap, dns.TypeNS))
+	assert.False(t, hasType(nsec.TypeBitMap, dns.TypeDS))
+	// only the NSEC is signed, not the NS records
+	assert.Equal(t, 1, verifySection(t, response.Ns, key))
+}
+
+func TestDNSSECNSEC3(t *testing.T) {
+	backend, username := setupDNSSEC(t, records.DNSSECSettings{Enabled: true, NSEC3: true})
+	key := getDNSKEY(t, backend, username)
+
+	response := queryDO(backend, domain(username), dns.TypeNSEC3PARAM)
+	assert.Equal(t, 1, verifySection(t, response.Answer, key))
+
+	nsec3s := func(response *dns.Msg) []*dns.NSEC3 {
+		found := []*dns.NSEC3{}
+		for _, rr := range response.Ns {
+			if n, ok := rr.(*dns.NSEC3); ok {
+				found = append(found, n)
+			}
+		}
+		return found
+	}
+	matches := func(nsec3s []*dns.NSEC3, name string) bool {
+		for _, n := range nsec3s {
+			if n.Match(name) {
+				return true
+			}
+		}
+		return false
+	}
+	covers := func(nsec3s []*dns.NSEC3, name string) bool {
+		for _, n := range nsec3s {
+			if n.Cover(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// NXDOMAIN: closest encloser proof, and no wildcard
+	response = queryDO(backend, "c.nope."+domain(username), dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	proof := nsec3s(response)
+	assert.True(t, matches(proof, domain(username)))
+	assert.True(t, covers(proof, "nope."+domain(username)))
+	assert.True(t, covers(proof, "*."+domain(username)))
+	verifySection(t, response.Ns, key)
+
+	// NODATA
+	response = queryDO(backend, "a.b."+domain(username), dns.TypeA)
+	proof = nsec3s(response)
+	assert.Equal(t, 1, len(proof))
+	assert.True(t, proof[0].Match("a.b."+domain(username)))
+	assert.True(t, hasType(proof[0].TypeBitMap, dns.TypeTXT))
+	assert.False(t, hasType(proof[0].TypeBitMap, dns.TypeA))
+	assert.Equal(t, 2, verifySection(t, response.Ns, key))
+
+	// empty non-terminals get NSEC3 records too
+	response = queryDO(backend, "b."+domain(username), dns.TypeA)
+	proof = nsec3s(response)
+	assert.True(t, proof[0].Match("b."+domain(username)))
+	assert.Equal(t, 0, len(proof[0].TypeBitMap))
+}
+
+func TestDNSSECBreak(t *testing.T) {
+	backend, username := setupDNSSEC(t, records.DNSSECSettings{Enabled: true, Break: records.BreakExpiredRRSIG})
+	key := getDNSKEY(t, backend, username)
+	response := queryDO(backend, domain(username), dns.TypeA)
+	sig := response.Answer[1].(*dns.RRSIG)
+	// the signature is fine, it's just expired
+	assert.NoError(t, sig.Verify(key, response.Answer[:1]))
+	assert.False(t, sig.ValidityPeriod(time.Now()))
+
+	rs := records.NewRecordService(backend)
+	ctx := context.Background()
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true, Break: records.BreakWrongDS}); err != nil {
+		t.Fatal(err)
+	}
+	// same key as before, but the DS doesn't match it any more
+	assert.Equal(t, key.String(), getDNSKEY(t, backend, username).String())
+	response = queryDO(backend, domain(username), dns.TypeDS)
+	assert.NotEqual(t, key.ToDS(dns.SHA256).Digest, response.Answer[0].(*dns.DS).Digest)
+
+	err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true, Break: "banana"})
+	assert.Equal(t, 400, err.Code)
+}
+
+func TestDNSSECRecords(t *testing.T) {
+	rs, backend, ctx, username := setupLocal(t)
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(recs))
+
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err = rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, "DNSKEY", recs[1].Record.Type)
+	assert.Equal(t, "257", recs[1].Record.Values["Flags"])
+	assert.Equal(t, "DS", recs[2].Record.Type)
+	assert.Equal(t, "@", recs[2].Record.Subdomain)
+
+	status, err := rs.GetDNSSEC(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, status.Enabled)
+	key := getDNSKEY(t, backend, username)
+	assert.Equal(t, key.String(), status.DNSKEY)
+
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	// just the SOA is left
+	recs, err = rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(recs))
+}
+
+func TestDNSSECPersistence(t *testing.T) {
+	ctx := context.Background()
+	username := generateUsername()
+	dbFile := filepath.Join(t.TempDir(), "records.sqlite")
+	backend, err := records.NewLocalBackend(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := records.NewRecordService(backend).SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true, NSEC3: true}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := backend.GetDNSSEC(ctx, domain(username))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = records.NewLocalBackend(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := backend.GetDNSSEC(ctx, domain(username))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, after)
+	response := queryDO(backend, domain(username), dns.TypeSOA)
+	key := getDNSKEY(t, backend, username)
+	assert.Equal(t, 1, verifySection(t, response.Answer, key))
+}
+
+// fakePowerDNSDNSSEC is enough of PowerDNS's zone and cryptokeys API to turn
+// DNSSEC on and off for one zone
+type fakePowerDNSDNSSEC struct {
+	zone       string
+	dnssec     bool
+	nsec3param string
+	dnskey     *dns.DNSKEY
+}
+
+func (f *fakePowerDNSDNSSEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/zones/"+f.zone):
+		fmt.Fprintf(w, `{"name": %q, "kind": "Native", "dnssec": %t, "nsec3param": %q, "rrsets": []}`, f.zone, f.dnssec, f.nsec3param)
+	case r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/zones/"+f.zone):
+		change := struct {
+			DNSSEC     *bool   `json:"dnssec"`
+			NSEC3Param *string `json:"nsec3param"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, `{"error": "bad json"}`, http.StatusBadRequest)
+			return
+		}
+		if change.DNSSEC != nil {
+			f.dnssec = *change.DNSSEC
+		}
+		f.nsec3param = ""
+		if change.NSEC3Param != nil {
+			f.nsec3param = *change.NSEC3Param
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/zones/"+f.zone+"/cryptokeys"):
+		if !f.dnssec {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		content := strings.TrimPrefix(f.dnskey.String(), f.dnskey.Hdr.String())
+		fmt.Fprintf(w, `[{"type": "Cryptokey", "id": 1, "keytype": "csk", "active": true, "dnskey": %q}]`, content)
+	default:
+		http.Error(w, `{"error": "Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func TestDNSSECPowerDNS(t *testing.T) {
+	ctx := context.Background()
+	username := generateUsername()
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: domain(username), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	if _, err := dnskey.Generate(256); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakePowerDNSDNSSEC{zone: domain(username), dnskey: dnskey}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	rs := records.Init(server.URL, "not-a-secret")
+
+	status, err := rs.GetDNSSEC(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, status.Enabled)
+
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true, NSEC3: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, fake.dnssec)
+	assert.Equal(t, "1 0 0 -", fake.nsec3param)
+	status, err = rs.GetDNSSEC(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, status.Enabled)
+	assert.True(t, status.NSEC3)
+	assert.Equal(t, dnskey.String(), status.DNSKEY)
+	assert.Equal(t, dnskey.ToDS(dns.SHA256).String(), status.DS)
+
+	// PowerDNS signs everything itself, so we can't break the signatures
+	err = rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true, Break: records.BreakWrongDS})
+	assert.Equal(t, 501, err.Code)
+	assert.True(t, fake.dnssec)
+
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", fake.nsec3param)
+	if err := rs.SetDNSSEC(ctx, username, records.DNSSECSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	status, err = rs.GetDNSSEC(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, records.DNSSECStatus{}, *status)
+}
@@ -0,0 +1,119 @@
+package records
+
+import (
+	"errors"
+	"net/http"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/parsing"
+)
+
+// Error codes are part of the API: the frontend and people's scripts check
+// them instead of the message, so don't change them. The codes for problems
+// with a single field of a record request are in the parsing package.
+const (
+	CodeInvalidRequest  = "invalid_request"
+	CodeNotLoggedIn     = "not_logged_in"
+	CodeNotFound        = "not_found"
+	CodeEditConflict    = "edit_conflict"
+	CodeInternalError   = "internal_error"
+	CodeNotImplemented  = "not_implemented"
+	CodeCNAMEConflict   = "cname_conflict"
+	CodeDuplicateRecord = "duplicate_record"
+	// for types like CNAME that only allow one record per name
+	CodeTooManyRecords = "too_many_records"
+	CodeInvalidName    = parsing.CodeInvalidName
+)
+
+// HTTPError is what the API returns when something goes wrong, as JSON.
+// Code is the HTTP status, ErrorCode is one of the codes above.
+type HTTPError struct {
+	Code      int    `json:"-"`
+	ErrorCode string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+func newHTTPError(code int, err error) *HTTPError {
+	httpErr := &HTTPError{
+		Code:      code,
+		ErrorCode: defaultErrorCode(code),
+		Message:   err.Error(),
+	}
+	if code := errorCode(err); code != "" {
+		httpErr.ErrorCode = code
+	}
+	var fieldErr parsing.FieldError
+	if errors.As(err, &fieldErr) {
+		httpErr.Field = fieldErr.Field()
+	}
+	return httpErr
+}
+
+// ToHTTPError is for errors from outside this package, so that every error
+// the API returns has the same format
+func ToHTTPError(code int, err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	return newHTTPError(code, err)
+}
+
+func defaultErrorCode(code int) string {
+	switch code {
+	case http.StatusUnauthorized:
+		return CodeNotLoggedIn
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeEditConflict
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	}
+	if code >= 500 {
+		return CodeInternalError
+	}
+	return CodeInvalidRequest
+}
+
+// codedError is an error with one of the codes above, like the errors from
+// TranslateError
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// rrsetError is an error from LocalBackend about one RRset in a PATCH, so
+// that we can tell which one without parsing the message. content is the
+// record it's about, if it's about one record.
+type rrsetError struct {
+	name    string
+	typ     powerdns.RRType
+	content string
+	err     error
+}
+
+func (e *rrsetError) Error() string { return e.err.Error() }
+func (e *rrsetError) Unwrap() error { return e.err }
+
+// errorCode is the code for an error, or "" if it doesn't have one
+func errorCode(err error) string {
+	var fieldErr parsing.FieldError
+	var coded *codedError
+	switch {
+	case errors.As(err, &fieldErr):
+		return fieldErr.Code()
+	case errors.As(err, &coded):
+		return coded.code
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package records_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/parsing"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodes(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	tests := []struct {
+		Record map[string]string
+		Code   string
+		Field  string
+	}{
+		{map[string]string{"subdomain": "new site", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, records.CodeInvalidName, ""},
+		{map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, "", ""},
+		{map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, records.CodeDuplicateRecord, ""},
+		{map[string]string{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": "example.com"}, records.CodeCNAMEConflict, ""},
+		{map[string]string{"subdomain": "blog", "type": "CNAME", "ttl": "60", "value_Target": "example.com"}, "", ""},
+		{map[string]string{"subdomain": "blog", "type": "CNAME", "ttl": "60", "value_Target": "example.org"}, records.CodeTooManyRecords, ""},
+		{map[string]string{"subdomain": "mail", "type": "MX", "ttl": "60", "value_Mx": "example.com"}, parsing.CodeMissingValue, "value_Preference"},
+		{map[string]string{"subdomain": "mail", "type": "A", "ttl": "forever", "value_A": "1.2.3.4"}, parsing.CodeInvalidTTL, "ttl"},
+	}
+	for _, test := range tests {
+		err := rs.CreateRecord(ctx, username, test.Record)
+		if test.Code == "" {
+			assert.Nil(t, err)
+			continue
+		}
+		assert.Equal(t, http.StatusBadRequest, err.Code)
+		assert.Equal(t, test.Code, err.ErrorCode)
+		assert.Equal(t, test.Field, err.Field)
+	}
+
+	// parsing errors keep their field when they're wrapped
+	_, err := rs.SyncZone(ctx, username, []map[string]string{{"subdomain": "www", "type": "AAAA", "ttl": "60", "value_AAAA": "banana"}}, true)
+	assert.Equal(t, parsing.CodeInvalidValue, err.ErrorCode)
+	assert.Equal(t, "value_AAAA", err.Field)
+
+	err = rs.DeleteRecord(ctx, username, "banana")
+	assert.Equal(t, records.CodeInvalidRequest, err.ErrorCode)
+}
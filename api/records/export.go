@@ -0,0 +1,97 @@
+package records
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportedRecord is a record in a form that's easy to copy somewhere else:
+// the name is relative to the user's zone and the content is in zone file
+// format
+type ExportedRecord struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     uint32 `json:"ttl"`
+	Content string `json:"content"`
+}
+
+// ExportRecords returns the user's records sorted like they'd be in a zone
+// file: the SOA first, then by name, with records of the same type next to
+// each other. DNSKEY and DS records are left out since we create those when
+// DNSSEC is on, and they can't be imported.
+func (rs RecordService) ExportRecords(ctx context.Context, username string) ([]ExportedRecord, *HTTPError) {
+	records, httpErr := rs.GetRecords(ctx, username)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	type sortable struct {
+		domainName string
+		record     ExportedRecord
+	}
+	sorted := []sortable{}
+	for _, r := range records {
+		if r.Record.Type == "DNSKEY" || r.Record.Type == "DS" {
+			continue
+		}
+		ttl, err := strconv.ParseUint(r.Record.TTL, 10, 32)
+		if err != nil {
+			return nil, newHTTPError(http.StatusInternalServerError, err)
+		}
+		sorted = append(sorted, sortable{
+			domainName: r.Record.DomainName,
+			record: ExportedRecord{
+				Name:    r.Record.Subdomain,
+				Type:    r.Record.Type,
+				TTL:     uint32(ttl),
+				Content: r.Record.Content,
+			},
+		})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if (a.record.Type == "SOA") != (b.record.Type == "SOA") {
+			return a.record.Type == "SOA"
+		}
+		if !strings.EqualFold(a.domainName, b.domainName) {
+			return canonicalLess(a.domainName, b.domainName)
+		}
+		return a.record.Type < b.record.Type
+	})
+	exported := []ExportedRecord{}
+	for _, s := range sorted {
+		exported = append(exported, s.record)
+	}
+	return exported, nil
+}
+
+// ZoneFile renders records as a BIND master file for the user's zone
+func ZoneFile(username string, records []ExportedRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s\n", zoneName(username))
+	for _, r := range records {
+		fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", r.Name, r.TTL, r.Type, r.Content)
+	}
+	return b.String()
+}
+
+func CSV(records []ExportedRecord) (string, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	err := w.Write([]string{"name", "type", "ttl", "content"})
+	if err != nil {
+		return "", err
+	}
+	for _, r := range records {
+		err = w.Write([]string{r.Name, r.Type, strconv.FormatUint(uint64(r.TTL), 10), r.Content})
+		if err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
@@ -0,0 +1,97 @@
+package records_test
+
+import (
+	"testing"
+
+	"github.com/jvns/mess-with-dns/parsing"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportRecords(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	_, err := rs.ImportRecords(ctx, username, `www 60 A 5.6.7.8
+@ 300 TXT "hello, world"
+www 60 A 1.2.3.4
+@ 300 A 1.2.3.4
+a.www 60 CNAME www
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	soa := exported[0]
+	assert.Equal(t, "SOA", soa.Type)
+	assert.Equal(t, []records.ExportedRecord{
+		soa,
+		{Name: "@", Type: "A", TTL: 300, Content: "1.2.3.4"},
+		{Name: "@", Type: "TXT", TTL: 300, Content: "\"hello, world\""},
+		{Name: "www", Type: "A", TTL: 60, Content: "5.6.7.8"},
+		{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+		{Name: "a.www", Type: "CNAME", TTL: 60, Content: "www." + domain(username)},
+	}, exported)
+
+	zoneFile := records.ZoneFile(username, exported)
+	assert.Equal(t, "$ORIGIN "+domain(username)+"\n"+
+		"@\t3600\tIN\tSOA\t"+soa.Content+"\n"+
+		"@\t300\tIN\tA\t1.2.3.4\n"+
+		"@\t300\tIN\tTXT\t\"hello, world\"\n"+
+		"www\t60\tIN\tA\t5.6.7.8\n"+
+		"www\t60\tIN\tA\t1.2.3.4\n"+
+		"a.www\t60\tIN\tCNAME\twww."+domain(username)+"\n", zoneFile)
+
+	csv, err2 := records.CSV(exported[1:3])
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	assert.Equal(t, "name,type,ttl,content\n@,A,300,1.2.3.4\n@,TXT,300,\"\"\"hello, world\"\"\"\n", csv)
+
+	// the zone file can be imported again
+	err = rs.DeleteAllRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := rs.ImportRecords(ctx, username, zoneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, result.Imported)
+	reexported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exported[1:], reexported[1:])
+}
+
+func TestExportAliasRoundTrip(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "ALIAS", "ttl": "3600", "value_Target": "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneFile := records.ZoneFile(username, exported)
+	assert.Contains(t, zoneFile, "@\t3600\tIN\tALIAS\texample.com.\n")
+
+	err = rs.DeleteAllRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := rs.ImportRecords(ctx, username, zoneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []parsing.LineError{}, result.Errors)
+	assert.Equal(t, 1, result.Imported)
+	reexported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exported[1:], reexported[1:])
+}
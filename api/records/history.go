@@ -0,0 +1,249 @@
+package records
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed history.sql
+var history_sql string
+
+// we only keep this many changes for each user
+const maxHistory = 100
+
+// History is a log of every change to users' records, so that they can see
+// what they changed and undo it when they break something. It also has the
+// snapshots they've saved (see snapshots.go).
+type History struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// Change is one edit to a user's records. Before and After only have the
+// records that changed: if an edit added a record, it's only in After.
+type Change struct {
+	ID       int64            `json:"id"`
+	Action   string           `json:"action"`
+	Time     time.Time        `json:"time"`
+	SourceIP string           `json:"source_ip"`
+	Before   []ExportedRecord `json:"before"`
+	After    []ExportedRecord `json:"after"`
+	// the RRsets that changed, as they were before the edit. An RRset with
+	// no records didn't exist.
+	before []powerdns.RRset
+}
+
+func NewHistory(dbFile string) (*History, error) {
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(history_sql)
+	if err != nil {
+		return nil, err
+	}
+	return &History{db: db, now: time.Now}, nil
+}
+
+func (h *History) add(ctx context.Context, username string, action string, before []powerdns.RRset, after []powerdns.RRset) error {
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, "INSERT INTO history (username, action, before, after, source_ip, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		username, action, string(beforeJSON), string(afterJSON), sourceIP(ctx), h.now().Unix())
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, "DELETE FROM history WHERE username = ? AND id NOT IN (SELECT id FROM history WHERE username = ? ORDER BY id DESC LIMIT ?)",
+		username, username, maxHistory)
+	return err
+}
+
+// list returns the user's changes, newest first
+func (h *History) list(ctx context.Context, username string) ([]Change, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, action, before, after, source_ip, created_at FROM history WHERE username = ? ORDER BY id DESC", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []Change{}
+	for rows.Next() {
+		change, err := scanChange(rows, username)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, rows.Err()
+}
+
+func (h *History) get(ctx context.Context, username string, id int64) (*Change, error) {
+	row := h.db.QueryRowContext(ctx, "SELECT id, action, before, after, source_ip, created_at FROM history WHERE username = ? AND id = ?", username, id)
+	return scanChange(row, username)
+}
+
+// DeleteOld deletes changes and snapshots from before the given time. The
+// zones they're about get deleted after a while anyway.
+func (h *History) DeleteOld(ctx context.Context, before time.Time) error {
+	_, err := h.db.ExecContext(ctx, "DELETE FROM history WHERE created_at < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, "DELETE FROM snapshots WHERE created_at < ?", before.Unix())
+	return err
+}
+
+func scanChange(row interface{ Scan(...any) error }, username string) (*Change, error) {
+	var change Change
+	var before, after string
+	var createdAt int64
+	err := row.Scan(&change.ID, &change.Action, &before, &after, &change.SourceIP, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	change.Time = time.Unix(createdAt, 0).UTC()
+	afterRRsets := []powerdns.RRset{}
+	err = json.Unmarshal([]byte(before), &change.before)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(after), &afterRRsets)
+	if err != nil {
+		return nil, err
+	}
+	change.Before = exportRRsets(change.before, username)
+	change.After = exportRRsets(afterRRsets, username)
+	return &change, nil
+}
+
+func exportRRsets(rrsets []powerdns.RRset, username string) []ExportedRecord {
+	exported := []ExportedRecord{}
+	for _, rrset := range rrsets {
+		for _, record := range rrset.Records {
+			exported = append(exported, ExportedRecord{
+				Name:    relativeName(*rrset.Name, username),
+				Type:    string(*rrset.Type),
+				TTL:     *rrset.TTL,
+				Content: *record.Content,
+			})
+		}
+	}
+	return exported
+}
+
+type sourceIPKey struct{}
+
+// WithSourceIP sets the IP address that edits with this context get logged
+// as coming from
+func WithSourceIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, sourceIPKey{}, ip)
+}
+
+func sourceIP(ctx context.Context) string {
+	ip, _ := ctx.Value(sourceIPKey{}).(string)
+	return ip
+}
+
+// WithHistory makes the RecordService save every edit in history
+func (rs RecordService) WithHistory(history *History) RecordService {
+	rs.history = history
+	return rs
+}
+
+// recordChange saves an edit in the user's history. before is the zone's
+// RRsets before the edit, and changed has the ones that the edit changed
+// (with ChangeType set). The edit has already happened, so if we can't save
+// it we just log the error.
+func (rs RecordService) recordChange(ctx context.Context, username string, action string, before []powerdns.RRset, changed []powerdns.RRset) {
+	if rs.history == nil {
+		return
+	}
+	oldRRsets, newRRsets := []powerdns.RRset{}, []powerdns.RRset{}
+	for _, rrset := range changed {
+		if rrset.ChangeType == nil || managedTypes[*rrset.Type] {
+			continue
+		}
+		old := powerdns.RRset{Name: rrset.Name, Type: rrset.Type, TTL: rrset.TTL, Records: []powerdns.Record{}}
+		for _, b := range before {
+			if strings.EqualFold(*b.Name, *rrset.Name) && *b.Type == *rrset.Type {
+				old = powerdns.RRset{Name: b.Name, Type: b.Type, TTL: b.TTL, Records: b.Records}
+				break
+			}
+		}
+		updated := powerdns.RRset{Name: rrset.Name, Type: rrset.Type, TTL: rrset.TTL, Records: rrset.Records}
+		if *rrset.ChangeType == powerdns.ChangeTypeDelete {
+			updated.Records = []powerdns.Record{}
+		}
+		oldRRsets = append(oldRRsets, old)
+		newRRsets = append(newRRsets, updated)
+	}
+	if len(oldRRsets) == 0 {
+		return
+	}
+	err := rs.history.add(ctx, username, action, oldRRsets, newRRsets)
+	if err != nil {
+		fmt.Printf("error saving history for %s: %s\n", username, err)
+	}
+}
+
+func (rs RecordService) GetHistory(ctx context.Context, username string) ([]Change, *HTTPError) {
+	if rs.history == nil {
+		return nil, newHTTPError(http.StatusNotImplemented, fmt.Errorf("history isn't enabled on this server"))
+	}
+	changes, err := rs.history.list(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	return changes, nil
+}
+
+// RevertChange puts the records that a change touched back the way they
+// were before it. Other records are left alone, and the revert is saved in
+// history too so that it can be undone.
+func (rs RecordService) RevertChange(ctx context.Context, username string, id int64) *HTTPError {
+	if rs.history == nil {
+		return newHTTPError(http.StatusNotImplemented, fmt.Errorf("history isn't enabled on this server"))
+	}
+	change, err := rs.history.get(ctx, username, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return newHTTPError(http.StatusNotFound, fmt.Errorf("Error: change %d not found", id))
+	}
+	if err != nil {
+		return newHTTPError(http.StatusInternalServerError, err)
+	}
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return httpErr
+	}
+	patch := []powerdns.RRset{}
+	for _, rrset := range change.before {
+		// an empty REPLACE deletes the RRset
+		replace := powerdns.ChangeTypeReplace
+		rrset.ChangeType = &replace
+		patch = append(patch, rrset)
+	}
+	err = rs.backend.PatchRRsets(ctx, zoneName(username), patch)
+	if err != nil {
+		return patchError(patch, err)
+	}
+	rs.recordChange(ctx, username, "revert", zone.RRsets, patch)
+	return nil
+}
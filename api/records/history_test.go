@@ -0,0 +1,136 @@
+package records_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupHistory(t *testing.T) (records.RecordService, context.Context, string) {
+	rs, _, ctx, username := setupLocal(t)
+	history, err := records.NewHistory(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.WithHistory(history), ctx, username
+}
+
+func TestHistory(t *testing.T) {
+	rs, ctx, username := setupHistory(t)
+	ctx = records.WithSourceIP(ctx, "1.2.3.4")
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "2.2.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.UpdateRecord(ctx, username, recs[1].ID, map[string]string{"subdomain": "www", "type": "A", "ttl": "300", "value_A": "3.3.3.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := rs.GetHistory(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(changes))
+	// newest first
+	update := changes[0]
+	assert.Equal(t, "update", update.Action)
+	assert.Equal(t, "1.2.3.4", update.SourceIP)
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "www", Type: "A", TTL: 60, Content: "1.1.1.1"},
+		{Name: "www", Type: "A", TTL: 60, Content: "2.2.2.2"},
+	}, update.Before)
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "www", Type: "A", TTL: 300, Content: "2.2.2.2"},
+		{Name: "www", Type: "A", TTL: 300, Content: "3.3.3.3"},
+	}, update.After)
+	assert.Equal(t, "create", changes[2].Action)
+	assert.Equal(t, []records.ExportedRecord{}, changes[2].Before)
+
+	// reverting the update puts both records back the way they were
+	err = rs.RevertChange(ctx, username, update.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "www", Type: "A", TTL: 60, Content: "1.1.1.1"},
+		{Name: "www", Type: "A", TTL: 60, Content: "2.2.2.2"},
+	}, exported[1:])
+
+	// the revert is in the history too, and reverting the first create
+	// deletes the RRset
+	changes, err = rs.GetHistory(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "revert", changes[0].Action)
+	err = rs.RevertChange(ctx, username, changes[3].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err = rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(exported))
+}
+
+func TestHistoryDeleteAll(t *testing.T) {
+	rs, ctx, username := setupHistory(t)
+	_, err := rs.ImportRecords(ctx, username, "www 60 A 1.2.3.4\n@ 60 TXT \"hi\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.DeleteAllRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := rs.GetHistory(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"delete_all", "import"}, []string{changes[0].Action, changes[1].Action})
+	assert.Equal(t, []records.ExportedRecord{}, changes[0].After)
+
+	err = rs.RevertChange(ctx, username, changes[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "@", Type: "TXT", TTL: 60, Content: "\"hi\""},
+		{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+	}, exported[1:])
+}
+
+func TestRevertOtherUsersChange(t *testing.T) {
+	rs, ctx, username := setupHistory(t)
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := rs.GetHistory(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpErr := rs.RevertChange(ctx, generateUsername(), changes[0].ID)
+	assert.Equal(t, http.StatusNotFound, httpErr.Code)
+}
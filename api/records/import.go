@@ -0,0 +1,63 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jvns/mess-with-dns/parsing"
+)
+
+type ImportResult struct {
+	Imported int                 `json:"imported"`
+	Errors   []parsing.LineError `json:"errors"`
+}
+
+// ImportRecords adds every record in a zone file to the user's zone. It's
+// all or nothing: if any line has a problem, nothing gets imported and the
+// result has an error for each bad line.
+func (rs RecordService) ImportRecords(ctx context.Context, username string, text string) (*ImportResult, *HTTPError) {
+	imported, lineErrors := parsing.ParseZoneFile(text, username)
+	if len(lineErrors) > 0 {
+		return &ImportResult{Errors: lineErrors}, nil
+	}
+	if len(imported) == 0 {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Errorf("Error: there aren't any records in that zone file"))
+	}
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	before := copyRRsets(zone.RRsets)
+	for _, record := range imported {
+		zoneAdd(zone, record.RRset)
+	}
+	// all the records go in one PATCH, so they either all work or none do
+	err := rs.updateZone(ctx, username, zone)
+	if err != nil {
+		if record := findImportedRecord(imported, err); record != nil {
+			translated := TranslateError(record.RRset, err)
+			return &ImportResult{Errors: []parsing.LineError{{
+				Line:    record.Line,
+				Message: translated.Error(),
+				Code:    errorCode(translated),
+			}}}, nil
+		}
+		return nil, patchError(zone.RRsets, err)
+	}
+	rs.recordChange(ctx, username, "import", before, zone.RRsets)
+	return &ImportResult{Imported: len(imported), Errors: []parsing.LineError{}}, nil
+}
+
+// findImportedRecord figures out which record an error from the backend is
+// about
+func findImportedRecord(imported []parsing.ZoneFileRecord, err error) *parsing.ZoneFileRecord {
+	for i, record := range imported {
+		if errorIsAbout(err, *record.RRset.Name, *record.RRset.Type, *record.RRset.Records[0].Content) {
+			return &imported[i]
+		}
+	}
+	return nil
+}
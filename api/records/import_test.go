@@ -0,0 +1,60 @@
+package records_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/parsing"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportRecords(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := rs.ImportRecords(ctx, username, "www 60 A 5.6.7.8\nblog CNAME www\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, result.Imported)
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SOA + 2 A records + CNAME
+	assert.Equal(t, 4, len(recs))
+
+	// if one record is bad, nothing gets imported
+	result, err = rs.ImportRecords(ctx, username, "new TXT \"hi\"\nwww A 1.2.3.4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, result.Imported)
+	assert.Equal(t, []parsing.LineError{
+		{Line: 2, Message: "Error: there's already a record with name www." + domain(username) + ", type A, and content 1.2.3.4", Code: records.CodeDuplicateRecord},
+	}, result.Errors)
+	result, err = rs.ImportRecords(ctx, username, "new TXT \"hi\"\nblog A 1.2.3.4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, result.Errors[0].Line)
+	recs, err = rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(recs))
+
+	_, err = rs.ImportRecords(ctx, username, "; just a comment\n")
+	assert.Equal(t, 400, err.Code)
+}
+
+func TestImportRecordsBackendDown(t *testing.T) {
+	_, backend, ctx, username := setupLocal(t)
+	rs := records.NewRecordService(brokenBackend{backend})
+	_, err := rs.ImportRecords(ctx, username, "www 60 A 1.2.3.4\n")
+	assert.Equal(t, http.StatusInternalServerError, err.Code)
+}
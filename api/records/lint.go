@@ -0,0 +1,231 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/parsing"
+	"github.com/miekg/dns"
+)
+
+// Warning is something in a zone that's allowed, but probably isn't what you
+// want. Code is for programs and Message is for people.
+type Warning struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warning codes are part of the API like the error codes, so scripts can
+// check them. Don't change them.
+const (
+	WarningTargetIsCNAME   = "target_is_cname"
+	WarningLowTTL          = "low_ttl"
+	WarningHighTTL         = "high_ttl"
+	WarningMissingGlue     = "missing_glue"
+	WarningCNAMEToNowhere  = "cname_to_nowhere"
+	WarningCAATag          = "caa_tag"
+	WarningDuplicateSPF    = "duplicate_spf"
+	WarningSPFLookups      = "spf_lookups"
+	WarningPrivateIP       = "private_ip"
+	WarningDocumentationIP = "documentation_ip"
+)
+
+const (
+	minTTL = 30
+	// a week
+	maxTTL = 604800
+	// RFC 7208 section 4.6.4
+	maxSPFLookups = 10
+)
+
+var documentationNets = parseCIDRs("192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// zoneIndex is the zone's records by (lowercase) name, so that lint checks
+// can look up what a target points to
+type zoneIndex map[string][]dns.RR
+
+func (idx zoneIndex) has(name string, rrtype uint16) bool {
+	for _, rr := range idx[strings.ToLower(name)] {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint checks a zone for common mistakes. It only knows about the zone
+// itself, so it can only check targets that are in the zone.
+func Lint(zone *powerdns.Zone) []Warning {
+	zoneName := strings.ToLower(*zone.Name)
+	inZone := func(name string) bool {
+		name = strings.ToLower(name)
+		return name == zoneName || strings.HasSuffix(name, "."+zoneName)
+	}
+	idx := zoneIndex{}
+	rrs := []dns.RR{}
+	for _, rrset := range zone.RRsets {
+		if *rrset.Type == powerdns.RRTypeSOA {
+			continue
+		}
+		for _, record := range rrset.Records {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", *rrset.Name, *rrset.TTL, *rrset.Type, *record.Content))
+			if err != nil || rr == nil {
+				continue
+			}
+			name := strings.ToLower(rr.Header().Name)
+			idx[name] = append(idx[name], rr)
+			rrs = append(rrs, rr)
+		}
+	}
+
+	warnings := []Warning{}
+	warn := func(rr dns.RR, code string, format string, args ...any) {
+		hdr := rr.Header()
+		warnings = append(warnings, Warning{
+			Name:    hdr.Name,
+			Type:    dns.TypeToString[hdr.Rrtype],
+			Content: strings.TrimPrefix(rr.String(), hdr.String()),
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	// targets of MX, NS and SRV records aren't allowed to be CNAMEs (RFC
+	// 2181 section 10.3)
+	checkTarget := func(rr dns.RR, target string) {
+		if inZone(target) && idx.has(target, dns.TypeCNAME) {
+			warn(rr, WarningTargetIsCNAME, "%s records aren't supposed to point at a CNAME, and %s is one", dns.TypeToString[rr.Header().Rrtype], target)
+		}
+	}
+	spfRecords := map[string]int{}
+
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Ttl < minTTL {
+			warn(rr, WarningLowTTL, "a TTL of %d seconds is very low, some resolvers will cache it for longer anyway", hdr.Ttl)
+		} else if hdr.Ttl > maxTTL {
+			warn(rr, WarningHighTTL, "a TTL of %d seconds is more than a week, so if you change this record it'll take a long time for everyone to see it", hdr.Ttl)
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			checkIP(rr, rr.A, warn)
+		case *dns.AAAA:
+			checkIP(rr, rr.AAAA, warn)
+		case *dns.MX:
+			checkTarget(rr, rr.Mx)
+		case *dns.SRV:
+			checkTarget(rr, rr.Target)
+		case *dns.NS:
+			checkTarget(rr, rr.Ns)
+			// if the nameserver is inside the zone it's for, resolvers need
+			// its IP from us (a glue record) to be able to find it
+			delegated := strings.ToLower(hdr.Name)
+			ns := strings.ToLower(rr.Ns)
+			if delegated != zoneName && (ns == delegated || strings.HasSuffix(ns, "."+delegated)) &&
+				!idx.has(ns, dns.TypeA) && !idx.has(ns, dns.TypeAAAA) {
+				warn(rr, WarningMissingGlue, "%s is inside %s, so it needs an A or AAAA record (a glue record) or nobody will be able to find it", rr.Ns, hdr.Name)
+			}
+		case *dns.CNAME:
+			if inZone(rr.Target) && len(idx[strings.ToLower(rr.Target)]) == 0 {
+				warn(rr, WarningCNAMEToNowhere, "%s doesn't have any records, so this CNAME doesn't go anywhere", rr.Target)
+			}
+		case *dns.CAA:
+			if rr.Tag != "issue" && rr.Tag != "issuewild" && rr.Tag != "iodef" {
+				warn(rr, WarningCAATag, "certificate authorities only understand the CAA tags issue, issuewild and iodef, not %q", rr.Tag)
+			}
+		case *dns.TXT:
+			txt := strings.Join(rr.Txt, "")
+			if txt != "v=spf1" && !strings.HasPrefix(txt, "v=spf1 ") {
+				continue
+			}
+			spfRecords[strings.ToLower(hdr.Name)]++
+			if spfRecords[strings.ToLower(hdr.Name)] == 2 {
+				warn(rr, WarningDuplicateSPF, "%s has more than one SPF record, so mail servers will ignore all of them", hdr.Name)
+			}
+			if lookups := spfLookups(txt); lookups > maxSPFLookups {
+				warn(rr, WarningSPFLookups, "this SPF record needs %d DNS lookups, but mail servers stop after %d", lookups, maxSPFLookups)
+			}
+		}
+	}
+	return warnings
+}
+
+func checkIP(rr dns.RR, ip net.IP, warn func(dns.RR, string, string, ...any)) {
+	switch {
+	case ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified():
+		warn(rr, WarningPrivateIP, "%s is a private IP address, so it'll only work on your own network", ip)
+	default:
+		for _, n := range documentationNets {
+			if n.Contains(ip) {
+				warn(rr, WarningDocumentationIP, "%s is reserved for documentation, so there's nothing there", ip)
+			}
+		}
+	}
+}
+
+// spfLookups counts the mechanisms in an SPF record that need a DNS lookup.
+// It doesn't follow includes, so the real number can be higher.
+func spfLookups(spf string) int {
+	lookups := 0
+	for _, term := range strings.Fields(spf)[1:] {
+		term = strings.ToLower(strings.TrimLeft(term, "+-~?"))
+		switch {
+		case term == "a" || term == "mx" || term == "ptr",
+			strings.HasPrefix(term, "a:") || strings.HasPrefix(term, "a/"),
+			strings.HasPrefix(term, "mx:") || strings.HasPrefix(term, "mx/"),
+			strings.HasPrefix(term, "ptr:"),
+			strings.HasPrefix(term, "include:"),
+			strings.HasPrefix(term, "exists:"),
+			strings.HasPrefix(term, "redirect="):
+			lookups++
+		}
+	}
+	return lookups
+}
+
+func (rs RecordService) LintRecords(ctx context.Context, username string) ([]Warning, *HTTPError) {
+	zone, err := rs.getOrCreateZone(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	return Lint(zone), nil
+}
+
+// LintRecord returns the warnings for the name that a record request is for,
+// so that we can show them after someone creates or updates a record. It's
+// only a hint, so if anything goes wrong there just aren't any warnings.
+func (rs RecordService) LintRecord(ctx context.Context, username string, record map[string]string) []Warning {
+	warnings := []Warning{}
+	rrset, err := parsing.ParseRecordRequest(record, username)
+	if err != nil {
+		return warnings
+	}
+	all, httpErr := rs.LintRecords(ctx, username)
+	if httpErr != nil {
+		return warnings
+	}
+	for _, w := range all {
+		if strings.EqualFold(w.Name, *rrset.Name) {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
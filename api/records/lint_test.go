@@ -0,0 +1,78 @@
+package records_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func lintCodes(t *testing.T, zoneFile string) map[string][]string {
+	rs, _, ctx, username := setupLocal(t)
+	result, err := rs.ImportRecords(ctx, username, zoneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors)
+	}
+	warnings, err := rs.LintRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := map[string][]string{}
+	for _, w := range warnings {
+		name := strings.TrimSuffix(w.Name, "."+domain(username))
+		if w.Name == domain(username) {
+			name = "@"
+		}
+		codes[name] = append(codes[name], w.Code)
+	}
+	return codes
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		zoneFile string
+		expected map[string][]string
+	}{
+		{"no warnings", "www 300 A 93.184.215.14\n@ 300 MX 10 mail\nmail 300 A 93.184.215.14\n", map[string][]string{}},
+		{"mx to cname", "@ 300 MX 10 mail\nmail 300 CNAME www\nwww 300 A 93.184.215.14\n", map[string][]string{"@": {records.WarningTargetIsCNAME}}},
+		{"srv to cname", "_sip._tcp 300 SRV 0 5 5060 sip\nsip 300 CNAME example.com.\n", map[string][]string{"_sip._tcp": {records.WarningTargetIsCNAME}}},
+		{"cname to nowhere", "www 300 CNAME nothing\n", map[string][]string{"www": {records.WarningCNAMEToNowhere}}},
+		{"cname outside zone", "www 300 CNAME example.com.\n", map[string][]string{}},
+		{"missing glue", "sub 300 NS ns1.sub\nother 300 NS ns1.other\nns1.other 300 A 93.184.215.14\n", map[string][]string{"sub": {records.WarningMissingGlue}}},
+		{"ttls", "low 5 A 93.184.215.14\nhigh 9999999 A 93.184.215.14\n", map[string][]string{"low": {records.WarningLowTTL}, "high": {records.WarningHighTTL}}},
+		{"duplicate spf", "@ 300 TXT \"v=spf1 mx -all\"\n@ 300 TXT \"v=spf1 a -all\"\n@ 300 TXT \"v=spf10\"\n", map[string][]string{"@": {records.WarningDuplicateSPF}}},
+		{"spf lookups", "@ 300 TXT \"v=spf1 a mx include:a.com include:b.com include:c.com include:d.com exists:e.com a:f.com mx:g.com ptr ~include:h.com -all\"\n", map[string][]string{"@": {records.WarningSPFLookups}}},
+		{"caa tag", "@ 300 CAA 0 issue \"letsencrypt.org\"\n@ 300 CAA 0 isue \"letsencrypt.org\"\n", map[string][]string{"@": {records.WarningCAATag}}},
+		{"private ips", "a 300 A 192.168.1.1\nb 300 A 127.0.0.1\nc 300 AAAA fd00::1\nd 300 A 192.0.2.1\ne 300 AAAA 2001:db8::1\n", map[string][]string{
+			"a": {records.WarningPrivateIP}, "b": {records.WarningPrivateIP}, "c": {records.WarningPrivateIP}, "d": {records.WarningDocumentationIP}, "e": {records.WarningDocumentationIP},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, lintCodes(t, test.zoneFile))
+		})
+	}
+}
+
+func TestLintRecord(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	record := map[string]string{"subdomain": "www", "type": "A", "ttl": "5", "value_A": "10.0.0.1"}
+	err := rs.CreateRecord(ctx, username, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "other", "type": "A", "ttl": "5", "value_A": "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the warnings for www
+	assert.Equal(t, []records.Warning{
+		{Name: "www." + domain(username), Type: "A", Content: "10.0.0.1", Code: records.WarningLowTTL, Message: "a TTL of 5 seconds is very low, some resolvers will cache it for longer anyway"},
+		{Name: "www." + domain(username), Type: "A", Content: "10.0.0.1", Code: records.WarningPrivateIP, Message: "10.0.0.1 is a private IP address, so it'll only work on your own network"},
+	}, rs.LintRecord(ctx, username, record))
+}
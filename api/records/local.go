@@ -0,0 +1,523 @@
+package records
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/miekg/dns"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed create.sql
+var create_sql string
+
+// LocalBackend keeps zones in memory instead of in PowerDNS, and can answer
+// DNS queries for them itself (see answer.go), so that we can run everything
+// in one process. If it has a database, zones get saved there too so that
+// they survive restarts.
+type LocalBackend struct {
+	mu    sync.RWMutex
+	zones map[string]*localZone
+	// DNSSEC keys, by zone
+	keys map[string]*zoneKey
+	db   *sql.DB
+	now  func() time.Time
+	// where we look up ALIAS targets that aren't in our zones
+	aliasResolver string
+}
+
+type localZone struct {
+	name   string
+	rrsets []powerdns.RRset
+	// the same records as rrsets, parsed and indexed by (lowercase) name
+	records   map[string][]dns.RR
+	createdAt time.Time
+	// static zones come from a zone file and can't be edited
+	static bool
+	// ALIAS records, by (lowercase) name. They aren't real DNS records, so
+	// they aren't in records: see answerAlias.
+	aliases map[string]localAlias
+}
+
+type localAlias struct {
+	target string
+	ttl    uint32
+}
+
+// same as default-soa-content in our PowerDNS config
+const defaultSOA = "mess-with-dns1.wizardzines.com. fake.example.com. %d 10800 3600 604800 3600"
+
+// NewLocalBackend loads zones from dbFile, or only keeps them in memory if
+// dbFile is empty
+func NewLocalBackend(dbFile string) (*LocalBackend, error) {
+	b := &LocalBackend{
+		zones:         map[string]*localZone{},
+		keys:          map[string]*zoneKey{},
+		now:           time.Now,
+		aliasResolver: defaultAliasResolver,
+	}
+	if dbFile == "" {
+		return b, nil
+	}
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(create_sql)
+	if err != nil {
+		return nil, err
+	}
+	b.db = db
+	rows, err := db.Query("SELECT name, rrsets, created_at FROM zones")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, data string
+		var createdAt int64
+		err = rows.Scan(&name, &data, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		rrsets := []powerdns.RRset{}
+		err = json.Unmarshal([]byte(data), &rrsets)
+		if err != nil {
+			return nil, fmt.Errorf("error loading zone %s: %s", name, err)
+		}
+		zone, err := newLocalZone(name, rrsets, time.Unix(createdAt, 0))
+		if err != nil {
+			return nil, fmt.Errorf("error loading zone %s: %s", name, err)
+		}
+		b.zones[name] = zone
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return b, b.loadKeys()
+}
+
+func (b *LocalBackend) loadKeys() error {
+	rows, err := b.db.Query("SELECT zone, settings, dnskey, private_key FROM dnssec_keys")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var zone, settings, dnskey, private string
+		err = rows.Scan(&zone, &settings, &dnskey, &private)
+		if err != nil {
+			return err
+		}
+		key, err := loadZoneKey(settings, dnskey, private)
+		if err != nil {
+			return fmt.Errorf("error loading DNSSEC key for %s: %s", zone, err)
+		}
+		b.keys[zone] = key
+	}
+	return rows.Err()
+}
+
+// LoadZoneFile serves a zone from a zone file, like PowerDNS's bind backend
+// does for messwithdns.com
+func (b *LocalBackend) LoadZoneFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zp := dns.NewZoneParser(f, "", filename)
+	rrsets := []powerdns.RRset{}
+	origin := ""
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if soa, ok := rr.(*dns.SOA); ok && origin == "" {
+			origin = strings.ToLower(soa.Hdr.Name)
+		}
+		name := strings.ToLower(rr.Header().Name)
+		typ := powerdns.RRType(dns.TypeToString[rr.Header().Rrtype])
+		content := strings.TrimPrefix(rr.String(), rr.Header().String())
+		rrsets = addToRRsets(rrsets, name, typ, rr.Header().Ttl, content)
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+	if origin == "" {
+		return fmt.Errorf("%s: zone has no SOA record", filename)
+	}
+	zone, err := newLocalZone(origin, rrsets, b.now())
+	if err != nil {
+		return err
+	}
+	zone.static = true
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.zones[origin] = zone
+	return nil
+}
+
+func addToRRsets(rrsets []powerdns.RRset, name string, typ powerdns.RRType, ttl uint32, content string) []powerdns.RRset {
+	for i := range rrsets {
+		if *rrsets[i].Name == name && *rrsets[i].Type == typ {
+			rrsets[i].Records = append(rrsets[i].Records, powerdns.Record{Content: &content})
+			return rrsets
+		}
+	}
+	return append(rrsets, powerdns.RRset{
+		Name:    &name,
+		Type:    &typ,
+		TTL:     &ttl,
+		Records: []powerdns.Record{{Content: &content}},
+	})
+}
+
+func newLocalZone(name string, rrsets []powerdns.RRset, createdAt time.Time) (*localZone, error) {
+	zone := &localZone{
+		name:      name,
+		rrsets:    rrsets,
+		records:   map[string][]dns.RR{},
+		createdAt: createdAt,
+		aliases:   map[string]localAlias{},
+	}
+	for _, rrset := range rrsets {
+		for _, record := range rrset.Records {
+			if *rrset.Type == powerdns.RRTypeALIAS {
+				alias, err := parseAlias(&rrset, *record.Content)
+				if err != nil {
+					return nil, err
+				}
+				zone.aliases[*rrset.Name] = alias
+				continue
+			}
+			rr, err := parseRecord(&rrset, *record.Content)
+			if err != nil {
+				return nil, err
+			}
+			zone.records[*rrset.Name] = append(zone.records[*rrset.Name], rr)
+		}
+	}
+	return zone, nil
+}
+
+func parseRecord(rrset *powerdns.RRset, content string) (dns.RR, error) {
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", *rrset.Name, *rrset.TTL, *rrset.Type, content))
+	if err != nil {
+		return nil, fmt.Errorf("Record %s/%s '%s': %s", *rrset.Name, *rrset.Type, content, err)
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("Record %s/%s '%s': empty record", *rrset.Name, *rrset.Type, content)
+	}
+	return rr, nil
+}
+
+// parseAlias parses an ALIAS record's content, which is the same as a
+// CNAME's
+func parseAlias(rrset *powerdns.RRset, content string) (localAlias, error) {
+	cname := powerdns.RRTypeCNAME
+	rr, err := parseRecord(&powerdns.RRset{Name: rrset.Name, Type: &cname, TTL: rrset.TTL}, content)
+	if err != nil {
+		return localAlias{}, fmt.Errorf("Record %s/ALIAS '%s': %s", *rrset.Name, content, err)
+	}
+	return localAlias{target: strings.ToLower(rr.(*dns.CNAME).Target), ttl: *rrset.TTL}, nil
+}
+
+func copyRRsets(rrsets []powerdns.RRset) []powerdns.RRset {
+	copied := make([]powerdns.RRset, len(rrsets))
+	for i, rrset := range rrsets {
+		copied[i] = rrset
+		copied[i].ChangeType = nil
+		copied[i].Records = append([]powerdns.Record{}, rrset.Records...)
+	}
+	return copied
+}
+
+func (b *LocalBackend) GetZone(ctx context.Context, name string) (*powerdns.Zone, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	zone, ok := b.zones[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrZoneNotFound
+	}
+	kind := powerdns.NativeZoneKind
+	return &powerdns.Zone{
+		Name:   &zone.name,
+		Kind:   &kind,
+		RRsets: copyRRsets(zone.rrsets),
+	}, nil
+}
+
+func (b *LocalBackend) AddZone(ctx context.Context, zone *powerdns.Zone) error {
+	name := strings.ToLower(*zone.Name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.zones[name]; ok {
+		return fmt.Errorf("Conflict: Domain '%s' already exists", name)
+	}
+	// PowerDNS creates an SOA record for new zones, so we do too
+	soaType := powerdns.RRTypeSOA
+	ttl := uint32(3600)
+	soa := fmt.Sprintf(defaultSOA, nextSerial(0, b.now()))
+	rrsets := []powerdns.RRset{{
+		Name:    &name,
+		Type:    &soaType,
+		TTL:     &ttl,
+		Records: []powerdns.Record{{Content: &soa}},
+	}}
+	newZone, err := newLocalZone(name, rrsets, b.now())
+	if err != nil {
+		return err
+	}
+	if len(zone.RRsets) > 0 {
+		newZone, err = newZone.patch(zone.RRsets)
+		if err != nil {
+			return err
+		}
+	}
+	return b.save(newZone)
+}
+
+func (b *LocalBackend) PatchRRsets(ctx context.Context, name string, rrsets []powerdns.RRset) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	zone, ok := b.zones[strings.ToLower(name)]
+	if !ok {
+		return ErrZoneNotFound
+	}
+	if zone.static {
+		return fmt.Errorf("zone %s can't be edited", zone.name)
+	}
+	patched, err := zone.patch(rrsets)
+	if err != nil {
+		return err
+	}
+	patched.bumpSerial(b.now())
+	return b.save(patched)
+}
+
+func (b *LocalBackend) DeleteZone(ctx context.Context, name string) error {
+	name = strings.ToLower(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	zone, ok := b.zones[name]
+	if !ok {
+		return ErrZoneNotFound
+	}
+	if zone.static {
+		return fmt.Errorf("zone %s can't be deleted", zone.name)
+	}
+	return b.delete(name)
+}
+
+func (b *LocalBackend) ListZones(ctx context.Context) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := []string{}
+	for name := range b.zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteOldZones deletes zones created before `before`, like
+// deleteOldRecords does for PowerDNS
+func (b *LocalBackend) DeleteOldZones(ctx context.Context, before time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, zone := range b.zones {
+		if zone.static || !zone.createdAt.Before(before) {
+			continue
+		}
+		err := b.delete(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save and delete need to be called with the lock held
+func (b *LocalBackend) save(zone *localZone) error {
+	if b.db != nil {
+		data, err := json.Marshal(zone.rrsets)
+		if err != nil {
+			return err
+		}
+		_, err = b.db.Exec("INSERT OR REPLACE INTO zones (name, rrsets, created_at) VALUES (?, ?, ?)", zone.name, string(data), zone.createdAt.Unix())
+		if err != nil {
+			return err
+		}
+	}
+	b.zones[zone.name] = zone
+	return nil
+}
+
+func (b *LocalBackend) delete(name string) error {
+	if b.db != nil {
+		_, err := b.db.Exec("DELETE FROM zones WHERE name = ?", name)
+		if err != nil {
+			return err
+		}
+		_, err = b.db.Exec("DELETE FROM dnssec_keys WHERE zone = ?", name)
+		if err != nil {
+			return err
+		}
+	}
+	delete(b.zones, name)
+	delete(b.keys, name)
+	return nil
+}
+
+// patch returns a copy of the zone with the changes applied. It checks the
+// same things PowerDNS does, but the errors that are the user's fault
+// already have codes, so they don't need TranslateError.
+func (zone *localZone) patch(changes []powerdns.RRset) (*localZone, error) {
+	rrsets := copyRRsets(zone.rrsets)
+	for _, change := range changes {
+		if change.Name == nil || change.Type == nil {
+			return nil, fmt.Errorf("RRset must have a name and a type")
+		}
+		name := strings.ToLower(*change.Name)
+		typ := *change.Type
+		if name != zone.name && !strings.HasSuffix(name, "."+zone.name) {
+			return nil, fmt.Errorf("RRset %s IN %s: Name is out of zone", name, typ)
+		}
+		if escaped, ok := checkNameCharacters(name); !ok {
+			if strings.Contains(escaped, "\\032") {
+				return nil, &rrsetError{name: name, typ: typ, err: spaceInNameError(*change.Name)}
+			}
+			return nil, &rrsetError{name: name, typ: typ, err: &codedError{CodeInvalidName, fmt.Errorf("Error: name \"%s\" contains characters that aren't allowed", *change.Name)}}
+		}
+		// remove the old records, and then add the new ones
+		kept := []powerdns.RRset{}
+		idx := -1
+		for _, rrset := range rrsets {
+			if *rrset.Name == name && *rrset.Type == typ {
+				idx = len(kept)
+				continue
+			}
+			kept = append(kept, rrset)
+		}
+		rrsets = kept
+		deleting := change.ChangeType != nil && *change.ChangeType == powerdns.ChangeTypeDelete
+		if deleting || len(change.Records) == 0 {
+			continue
+		}
+		if change.TTL == nil {
+			return nil, fmt.Errorf("RRset %s IN %s: TTL is required", name, typ)
+		}
+		if (typ == powerdns.RRTypeCNAME || typ == powerdns.RRTypeDNAME || typ == powerdns.RRTypeALIAS) && len(change.Records) > 1 {
+			return nil, &rrsetError{name: name, typ: typ, err: tooManyRecordsError(name, typ)}
+		}
+		seen := map[string]bool{}
+		for _, record := range change.Records {
+			if seen[*record.Content] {
+				return nil, &rrsetError{name: name, typ: typ, content: *record.Content, err: duplicateRecordError(name, typ, *record.Content)}
+			}
+			seen[*record.Content] = true
+		}
+		newRRset := change
+		newRRset.Name = &name
+		newRRset.ChangeType = nil
+		newRRset.Records = append([]powerdns.Record{}, change.Records...)
+		// keep the RRset in the same place if it already existed
+		if idx == -1 {
+			idx = len(rrsets)
+		}
+		rrsets = append(rrsets[:idx], append([]powerdns.RRset{newRRset}, rrsets[idx:]...)...)
+	}
+	if err := checkCNAMEConflicts(rrsets, changes); err != nil {
+		return nil, err
+	}
+	patched, err := newLocalZone(zone.name, rrsets, zone.createdAt)
+	if err != nil {
+		return nil, err
+	}
+	patched.static = zone.static
+	return patched, nil
+}
+
+func checkCNAMEConflicts(rrsets []powerdns.RRset, changes []powerdns.RRset) error {
+	types := map[string][]powerdns.RRType{}
+	for _, rrset := range rrsets {
+		types[*rrset.Name] = append(types[*rrset.Name], *rrset.Type)
+	}
+	for _, change := range changes {
+		name := strings.ToLower(*change.Name)
+		if len(change.Records) == 0 || len(types[name]) < 2 {
+			continue
+		}
+		conflict := &rrsetError{name: name, typ: *change.Type, err: cnameConflictError(name)}
+		if *change.Type == powerdns.RRTypeCNAME {
+			return conflict
+		}
+		for _, typ := range types[name] {
+			if typ == powerdns.RRTypeCNAME {
+				return conflict
+			}
+		}
+	}
+	return nil
+}
+
+// checkNameCharacters returns the name with any characters PowerDNS wouldn't
+// allow escaped (so a space turns into \032)
+func checkNameCharacters(name string) (string, bool) {
+	ok := true
+	var escaped strings.Builder
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '*', c == '.':
+			escaped.WriteByte(c)
+		default:
+			ok = false
+			fmt.Fprintf(&escaped, "\\%03d", c)
+		}
+	}
+	return escaped.String(), ok
+}
+
+// bumpSerial updates the SOA serial after a change, in the same YYYYMMDDNN
+// format that ParseSerial expects
+func (zone *localZone) bumpSerial(now time.Time) {
+	soa, ok := zone.soa()
+	if !ok {
+		return
+	}
+	soa.Serial = nextSerial(soa.Serial, now)
+	for i, rrset := range zone.rrsets {
+		if *rrset.Type == powerdns.RRTypeSOA {
+			content := strings.TrimPrefix(soa.String(), soa.Hdr.String())
+			zone.rrsets[i].Records = []powerdns.Record{{Content: &content}}
+		}
+	}
+}
+
+func nextSerial(serial uint32, now time.Time) uint32 {
+	var today uint32
+	fmt.Sscanf(now.UTC().Format("20060102"), "%d", &today)
+	if serial < today*100 {
+		return today * 100
+	}
+	return serial + 1
+}
+
+// soa returns the zone's SOA record (which is shared with zone.records, so
+// changing it changes the zone)
+func (zone *localZone) soa() (*dns.SOA, bool) {
+	for _, rr := range zone.records[zone.name] {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,175 @@
+package records_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLocal(t *testing.T) (records.RecordService, *records.LocalBackend, context.Context, string) {
+	backend, err := records.NewLocalBackend("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records.NewRecordService(backend), backend, context.Background(), generateUsername()
+}
+
+func TestLocalCreateUpdateDelete(t *testing.T) {
+	rs, backend, ctx, username := setupLocal(t)
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "5.6.7.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SOA + 2 A records
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, "SOA", recs[0].Record.Type)
+
+	id := records.PdnsID{Name: domain(username), Type: powerdns.RRTypeA, Content: "1.2.3.4"}.String()
+	err = rs.UpdateRecord(ctx, username, id, map[string]string{"subdomain": "BANANA", "type": "A", "ttl": "60", "value_A": "2.3.4.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = records.PdnsID{Name: domain(username), Type: powerdns.RRTypeA, Content: "5.6.7.8"}.String()
+	err = rs.DeleteRecord(ctx, username, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err = rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, "banana", recs[1].Record.Subdomain)
+	assert.Equal(t, "2.3.4.5", recs[1].Record.Values["A"])
+
+	err = rs.DeleteAllRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err2 := backend.GetZone(ctx, domain(username))
+	assert.Equal(t, records.ErrZoneNotFound, err2)
+}
+
+func TestLocalErrors(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	tests := []ErrorTest{
+		{map[string]string{"subdomain": "new site", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, "Error: name \"new site.%s.messwithdns.com.\" contains a space"},
+		{map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, ""},
+		{map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, "Error: there's already a record with name www.%s.messwithdns.com., type A, and content 1.2.3.4"},
+		{map[string]string{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": "example.com"}, "Error: can't create record for www.%s.messwithdns.com.: CNAME records aren't allowed to coexist with other records"},
+		{map[string]string{"subdomain": "blog", "type": "CNAME", "ttl": "60", "value_Target": "example.com"}, ""},
+		{map[string]string{"subdomain": "blog", "type": "CNAME", "ttl": "60", "value_Target": "example.org"}, "Error: a name is only allowed to have one CNAME record, and blog.%s.messwithdns.com. already has one"},
+		{map[string]string{"subdomain": "blog", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, "Error: can't create record for blog.%s.messwithdns.com.: CNAME records aren't allowed to coexist with other records"},
+		// ALIAS records can be next to other records, but there can only be one
+		{map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}, ""},
+		{map[string]string{"subdomain": "@", "type": "ALIAS", "ttl": "60", "value_Target": "example.com"}, ""},
+		{map[string]string{"subdomain": "@", "type": "ALIAS", "ttl": "60", "value_Target": "example.org"}, "Error: a name is only allowed to have one ALIAS record, and %s.messwithdns.com. already has one"},
+	}
+	for _, test := range tests {
+		err := rs.CreateRecord(ctx, username, test.Record)
+		if test.Error == "" {
+			assert.Nil(t, err)
+			continue
+		}
+		assert.Equal(t, fmt.Sprintf(test.Error, username), err.Error())
+	}
+}
+
+func TestLocalErrorCodes(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	record := map[string]string{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}
+	assert.Nil(t, rs.CreateRecord(ctx, username, record))
+	err := rs.CreateRecord(ctx, username, record)
+	assert.Equal(t, http.StatusBadRequest, err.Code)
+	assert.Equal(t, records.CodeDuplicateRecord, err.ErrorCode)
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": "example.com"})
+	assert.Equal(t, http.StatusBadRequest, err.Code)
+	assert.Equal(t, records.CodeCNAMEConflict, err.ErrorCode)
+}
+
+func TestLocalSerial(t *testing.T) {
+	rs, backend, ctx, username := setupLocal(t)
+	serial := func() uint32 {
+		zone, err := backend.GetZone(ctx, domain(username))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var serial uint32
+		fmt.Sscanf(*zone.RRsets[0].Records[0].Content, "mess-with-dns1.wizardzines.com. fake.example.com. %d", &serial)
+		return serial
+	}
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := serial()
+	date, err2 := records.ParseSerial(first)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02"), date.Format("2006-01-02"))
+
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "A", "ttl": "60", "value_A": "5.6.7.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, first+1, serial())
+}
+
+func TestLocalPersistence(t *testing.T) {
+	ctx := context.Background()
+	username := generateUsername()
+	dbFile := filepath.Join(t.TempDir(), "records.sqlite")
+	backend, err := records.NewLocalBackend(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := records.NewRecordService(backend)
+	httpErr := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "@", "type": "TXT", "ttl": "60", "value_Txt": "hello world"})
+	if httpErr != nil {
+		t.Fatal(httpErr)
+	}
+
+	// a new backend should load the zone from the database
+	backend, err = records.NewLocalBackend(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err := backend.ListZones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{domain(username)}, zones)
+	recs, httpErr := records.NewRecordService(backend).GetRecords(ctx, username)
+	if httpErr != nil {
+		t.Fatal(httpErr)
+	}
+	assert.Equal(t, "hello world", recs[1].Record.Values["Txt"])
+
+	err = backend.DeleteOldZones(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err = backend.ListZones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{}, zones)
+}
@@ -13,35 +13,31 @@ import (
 )
 
 type RecordService struct {
-	pdns *powerdns.Client
+	backend Backend
+	locks   *zoneLocks
+	// nil if we're not keeping history
+	history *History
+	// the zones' names for Wildcard
+	wildcards *wildcardCache
 }
 
+// Init creates a RecordService that stores records in PowerDNS
 func Init(url string, api_key string) RecordService {
-	pdns := powerdns.NewClient(url, "localhost", map[string]string{"X-API-Key": api_key}, nil)
-	return RecordService{pdns: pdns}
+	return NewRecordService(NewPowerDNSBackend(url, api_key))
 }
 
-type HTTPError struct {
-	Code    int
-	Message string
-}
-
-func (e *HTTPError) Error() string {
-	return e.Message
-}
-
-func newHTTPError(code int, err error) *HTTPError {
-	return &HTTPError{
-		Code:    code,
-		Message: err.Error(),
-	}
+func NewRecordService(backend Backend) RecordService {
+	return RecordService{backend: backend, locks: newZoneLocks(), wildcards: newWildcardCache()}
 }
 
 func (rs RecordService) DeleteRecord(ctx context.Context, username string, id string) *HTTPError {
-	zone, err := rs.getOrCreateZone(ctx, username)
-	if err != nil {
-		return newHTTPError(http.StatusInternalServerError, err)
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return httpErr
 	}
+	before := copyRRsets(zone.RRsets)
 	pdnsID, err := ParseID(id)
 	if err != nil {
 		return newHTTPError(http.StatusBadRequest, err)
@@ -54,14 +50,18 @@ func (rs RecordService) DeleteRecord(ctx context.Context, username string, id st
 	if err != nil {
 		return newHTTPError(http.StatusInternalServerError, err)
 	}
+	rs.recordChange(ctx, username, "delete", before, zone.RRsets)
 	return nil
 }
 
 func (rs RecordService) CreateRecord(ctx context.Context, username string, record map[string]string) *HTTPError {
-	zone, err := rs.getOrCreateZone(ctx, username)
-	if err != nil {
-		return newHTTPError(http.StatusInternalServerError, err)
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return httpErr
 	}
+	before := copyRRsets(zone.RRsets)
 	newRRset, err := parsing.ParseRecordRequest(record, username)
 	if err != nil {
 		return newHTTPError(http.StatusBadRequest, err)
@@ -70,16 +70,20 @@ func (rs RecordService) CreateRecord(ctx context.Context, username string, recor
 	zoneAdd(zone, newRRset)
 	err = rs.updateZone(ctx, username, zone)
 	if err != nil {
-		return newHTTPError(http.StatusInternalServerError, TranslateError(newRRset, err))
+		return updateError(newRRset, err)
 	}
+	rs.recordChange(ctx, username, "create", before, zone.RRsets)
 	return nil
 }
 
 func (rs RecordService) UpdateRecord(ctx context.Context, username string, id string, record map[string]string) *HTTPError {
-	zone, err := rs.getOrCreateZone(ctx, username)
-	if err != nil {
-		return newHTTPError(http.StatusInternalServerError, err)
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return httpErr
 	}
+	before := copyRRsets(zone.RRsets)
 	pdnsID, err := ParseID(id)
 	if err != nil {
 		return newHTTPError(http.StatusBadRequest, err)
@@ -95,17 +99,35 @@ func (rs RecordService) UpdateRecord(ctx context.Context, username string, id st
 	zoneAdd(zone, newRRset)
 	err = rs.updateZone(ctx, username, zone)
 	if err != nil {
-		return newHTTPError(http.StatusInternalServerError, TranslateError(newRRset, err))
+		return updateError(newRRset, err)
 	}
+	rs.recordChange(ctx, username, "update", before, zone.RRsets)
 	return nil
 }
 
 func (rs RecordService) DeleteAllRecords(ctx context.Context, username string) *HTTPError {
+	unlock := rs.lockZone(username)
+	defer unlock()
 	name := zoneName(username)
-	err := rs.pdns.Zones.Delete(ctx, name)
+	zone, err := rs.backend.GetZone(ctx, name)
+	if err == nil {
+		if httpErr := checkSerial(ctx, zone); httpErr != nil {
+			return httpErr
+		}
+	}
+	err = rs.backend.DeleteZone(ctx, name)
 	if err != nil {
 		return newHTTPError(http.StatusInternalServerError, err)
 	}
+	if zone != nil {
+		deleted := []powerdns.RRset{}
+		for _, rrset := range zone.RRsets {
+			deleteType := powerdns.ChangeTypeDelete
+			rrset.ChangeType = &deleteType
+			deleted = append(deleted, rrset)
+		}
+		rs.recordChange(ctx, username, "delete_all", zone.RRsets, deleted)
+	}
 	return nil
 }
 
@@ -117,7 +139,7 @@ func (rs RecordService) CreateZone(ctx context.Context, username string) (*power
 		Kind:   &kind,
 		RRsets: []powerdns.RRset{},
 	}
-	_, err := rs.pdns.Zones.Add(ctx, &zone)
+	err := rs.backend.AddZone(ctx, &zone)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +148,7 @@ func (rs RecordService) CreateZone(ctx context.Context, username string) (*power
 
 func (rs RecordService) getOrCreateZone(ctx context.Context, username string) (*powerdns.Zone, error) {
 	zoneName := zoneName(username)
-	zone, err := rs.pdns.Zones.Get(ctx, zoneName)
+	zone, err := rs.backend.GetZone(ctx, zoneName)
 	if err != nil {
 		return rs.CreateZone(ctx, username)
 	}
@@ -142,7 +164,7 @@ func (rs RecordService) updateZone(ctx context.Context, username string, zone *p
 			rrsets = append(rrsets, rrset)
 		}
 	}
-	err := rs.pdns.Records.Patch(ctx, zoneName, &powerdns.RRsets{rrsets})
+	err := rs.backend.PatchRRsets(ctx, zoneName, rrsets)
 	if err != nil {
 		return err
 	}
@@ -226,21 +248,34 @@ func ParseSerial(serial uint32) (time.Time, error) {
 }
 
 func (rs RecordService) GetRecords(ctx context.Context, username string) ([]Record, *HTTPError) {
+	records, _, err := rs.GetRecordsAndSerial(ctx, username)
+	return records, err
+}
+
+// GetRecordsAndSerial also returns the zone's SOA serial, which changes every
+// time the zone does. Pass it to IfMatch to make sure an edit doesn't
+// overwrite changes you haven't seen.
+func (rs RecordService) GetRecordsAndSerial(ctx context.Context, username string) ([]Record, uint32, *HTTPError) {
 	zone, err := rs.getOrCreateZone(ctx, username)
 	if err != nil {
 		// not right, should probably be a 404
-		return nil, newHTTPError(http.StatusInternalServerError, err)
+		return nil, 0, newHTTPError(http.StatusInternalServerError, err)
+	}
+	// show the DNSSEC records too, if it's on
+	dnssecRRsets, err := rs.dnssecRecords(ctx, zoneName(username))
+	if err != nil {
+		return nil, 0, newHTTPError(http.StatusInternalServerError, err)
 	}
 	// convert zone to RecordRequest
 	records := []Record{}
-	for _, rrset := range zone.RRsets {
+	for _, rrset := range append(zone.RRsets, dnssecRRsets...) {
 		// filter out SOA and NS records
 		//if *rrset.Type == powerdns.RRTypeSOA || *rrset.Type == powerdns.RRTypeNS {
 		//	continue
 		//}
 		responses, err := parsing.RRsetToRecordResponse(&rrset)
 		if err != nil {
-			return nil, newHTTPError(http.StatusInternalServerError, err)
+			return nil, 0, newHTTPError(http.StatusInternalServerError, err)
 		}
 		for _, resp := range responses {
 			pdnsID := PdnsID{
@@ -254,7 +289,7 @@ func (rs RecordService) GetRecords(ctx context.Context, username string) ([]Reco
 			})
 		}
 	}
-	return records, nil
+	return records, zoneSerial(zone), nil
 }
 
 type PdnsID struct {
@@ -271,6 +306,9 @@ func (id PdnsID) String() string {
 func ParseID(id string) (*PdnsID, error) {
 	// format of id: www.example.com|A|base64(content)
 	parts := strings.SplitN(id, "|", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid record ID: %s", id)
+	}
 	content, err := base64.StdEncoding.DecodeString(parts[2])
 	if err != nil {
 		return nil, err
@@ -1,32 +1,88 @@
 package records
 
 import (
+	"errors"
 	"fmt"
 	powerdns "github.com/joeig/go-powerdns/v3"
+	"net/http"
 	"strings"
 )
 
+// TranslateError turns PowerDNS's error messages into something people can
+// understand, with an error code. PowerDNS only gives us text, so this is
+// the one place we match on it. LocalBackend's errors already have codes.
 func TranslateError(rrset *powerdns.RRset, err error) error {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return err
+	}
 	errorString := err.Error()
 	name := *rrset.Name
 	typ := *rrset.Type
 	content := *rrset.Records[0].Content
 
 	// RRset test.pear5.messwithdns.com. IN CNAME: Conflicts with pre-existing RRset
-	if strings.Contains(errorString, "Conflicts with pre-existing RRset") {
-		return fmt.Errorf("Error: can't create record for %s: CNAME records aren't allowed to coexist with other records", name)
+	// RRset test.pear5.messwithdns.com. IN A: Conflicts with pre-existing CNAME RRset
+	if strings.Contains(errorString, "Conflicts with pre-existing RRset") || strings.Contains(errorString, "Conflicts with pre-existing CNAME RRset") {
+		return cnameConflictError(name)
 	}
 	// Duplicate record in RRset test.pear5.messwithdns.com. IN A with content "1.2.3.5"
 	if strings.Contains(errorString, "Duplicate record in RRset") {
-		return fmt.Errorf("Error: there's already a record with name %s, type %s, and content %s", name, typ, content)
+		return duplicateRecordError(name, typ, content)
 	}
 	// RRset test2.pear5.messwithdns.com. IN CNAME has more than one record
 	if strings.Contains(errorString, "has more than one record") {
-		return fmt.Errorf("Error: a name is only allowed to have one %s record, and %s already has one", typ, name)
+		return tooManyRecordsError(name, typ)
 	}
 	//  Name 'new\032site.island358.messwithdns.com.' contains unsupported characters (only translate if \032 is present)
 	if strings.Contains(errorString, "contains unsupported characters") && strings.Contains(errorString, "\\032") {
-		return fmt.Errorf("Error: name \"%s\" contains a space", name)
+		return spaceInNameError(name)
 	}
 	return err
 }
+
+func cnameConflictError(name string) error {
+	return &codedError{CodeCNAMEConflict, fmt.Errorf("Error: can't create record for %s: CNAME records aren't allowed to coexist with other records", name)}
+}
+
+func duplicateRecordError(name string, typ powerdns.RRType, content string) error {
+	return &codedError{CodeDuplicateRecord, fmt.Errorf("Error: there's already a record with name %s, type %s, and content %s", name, typ, content)}
+}
+
+func tooManyRecordsError(name string, typ powerdns.RRType) error {
+	return &codedError{CodeTooManyRecords, fmt.Errorf("Error: a name is only allowed to have one %s record, and %s already has one", typ, name)}
+}
+
+func spaceInNameError(name string) error {
+	return &codedError{CodeInvalidName, fmt.Errorf("Error: name \"%s\" contains a space", name)}
+}
+
+// errorIsAbout says whether an error from PatchRRsets is about the RRset
+// name/typ, and about the record with that content if it's a duplicate
+// (content can be "" to match any record). LocalBackend's errors say which
+// RRset they're about, but PowerDNS's look like "RRset www.example.com. IN
+// A: ..." or "Duplicate record in RRset www.example.com. IN A with content
+// ...".
+func errorIsAbout(err error, name string, typ powerdns.RRType, content string) bool {
+	var rrsetErr *rrsetError
+	if errors.As(err, &rrsetErr) {
+		return strings.EqualFold(rrsetErr.name, name) && rrsetErr.typ == typ &&
+			(content == "" || rrsetErr.content == "" || rrsetErr.content == content)
+	}
+	errorString := err.Error()
+	if !strings.Contains(errorString, fmt.Sprintf("RRset %s IN %s", name, typ)) {
+		return false
+	}
+	return content == "" || !strings.Contains(errorString, "with content") || strings.Contains(errorString, content)
+}
+
+// updateError is the API error for a failed update to rrset. The errors we
+// know about are the user's fault, anything else is probably ours.
+func updateError(rrset *powerdns.RRset, err error) *HTTPError {
+	err = TranslateError(rrset, err)
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return newHTTPError(http.StatusBadRequest, err)
+	}
+	return newHTTPError(http.StatusInternalServerError, err)
+}
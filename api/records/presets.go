@@ -0,0 +1,220 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/parsing"
+)
+
+// Preset is a group of records that people often set up together, like all
+// the records you need for email
+type Preset struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Records     []PresetRecord `json:"records"`
+}
+
+// PresetRecord is in the same format as a record request from the UI. The
+// subdomain is relative to the subdomain the preset gets applied to.
+type PresetRecord struct {
+	Subdomain string            `json:"subdomain"`
+	Type      string            `json:"type"`
+	TTL       string            `json:"ttl"`
+	Values    map[string]string `json:"values"`
+}
+
+var Presets = []Preset{
+	{
+		Name:        "email",
+		Description: "Receive email at mail.example.com, with SPF, DKIM and DMARC so that other mail servers trust email you send",
+		Records: []PresetRecord{
+			{"@", "MX", "3600", map[string]string{"Preference": "10", "Mx": "mail.example.com"}},
+			{"@", "TXT", "3600", map[string]string{"Txt": "v=spf1 mx -all"}},
+			{"default._domainkey", "TXT", "3600", map[string]string{"Txt": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}},
+			{"_dmarc", "TXT", "3600", map[string]string{"Txt": "v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com"}},
+		},
+	},
+	{
+		Name:        "github-pages",
+		Description: "Host a site on GitHub Pages, with www redirecting to it",
+		Records: []PresetRecord{
+			{"@", "A", "3600", map[string]string{"A": "185.199.108.153"}},
+			{"@", "A", "3600", map[string]string{"A": "185.199.109.153"}},
+			{"@", "A", "3600", map[string]string{"A": "185.199.110.153"}},
+			{"@", "A", "3600", map[string]string{"A": "185.199.111.153"}},
+			{"@", "AAAA", "3600", map[string]string{"AAAA": "2606:50c0:8000::153"}},
+			{"@", "AAAA", "3600", map[string]string{"AAAA": "2606:50c0:8001::153"}},
+			{"@", "AAAA", "3600", map[string]string{"AAAA": "2606:50c0:8002::153"}},
+			{"@", "AAAA", "3600", map[string]string{"AAAA": "2606:50c0:8003::153"}},
+			{"www", "CNAME", "3600", map[string]string{"Target": "example.github.io"}},
+		},
+	},
+	{
+		Name:        "google-site-verification",
+		Description: "Prove to Google that you own the domain",
+		Records: []PresetRecord{
+			{"@", "TXT", "3600", map[string]string{"Txt": "google-site-verification=rXOxyZounnZasA8Z7oaD3c14JdjS9aKSWvsR1EbUSIQ"}},
+		},
+	},
+	{
+		Name:        "xmpp",
+		Description: "Tell XMPP (Jabber) clients and servers where your chat server is",
+		Records: []PresetRecord{
+			{"_xmpp-client._tcp", "SRV", "3600", map[string]string{"Priority": "5", "Weight": "0", "Port": "5222", "Target": "xmpp.example.com"}},
+			{"_xmpp-server._tcp", "SRV", "3600", map[string]string{"Priority": "5", "Weight": "0", "Port": "5269", "Target": "xmpp.example.com"}},
+		},
+	},
+	{
+		Name:        "minecraft",
+		Description: "Let people join your Minecraft server without typing the port",
+		Records: []PresetRecord{
+			{"_minecraft._tcp", "SRV", "3600", map[string]string{"Priority": "0", "Weight": "5", "Port": "25565", "Target": "mc.example.com"}},
+		},
+	},
+	{
+		Name:        "letsencrypt-caa",
+		Description: "Only allow Let's Encrypt to issue certificates for the domain",
+		Records: []PresetRecord{
+			{"@", "CAA", "3600", map[string]string{"Flag": "0", "Tag": "issue", "Value": "letsencrypt.org"}},
+			{"@", "CAA", "3600", map[string]string{"Flag": "0", "Tag": "iodef", "Value": "mailto:security@example.com"}},
+		},
+	},
+}
+
+// PresetResult is what happened when applying a preset. If any of its records
+// conflict with existing ones, nothing gets added and Conflicts says why.
+type PresetResult struct {
+	Added     []ExportedRecord `json:"added"`
+	Conflicts []PresetConflict `json:"conflicts"`
+}
+
+type PresetConflict struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+func findPreset(name string) *Preset {
+	for i, preset := range Presets {
+		if preset.Name == name {
+			return &Presets[i]
+		}
+	}
+	return nil
+}
+
+// presetRRsets turns a preset's records into RRsets for a subdomain ("@"
+// for the whole zone)
+func presetRRsets(preset *Preset, subdomain string, username string) ([]*powerdns.RRset, error) {
+	rrsets := []*powerdns.RRset{}
+	for _, record := range preset.Records {
+		name := record.Subdomain
+		switch {
+		case subdomain == "@":
+		case name == "@":
+			name = subdomain
+		default:
+			name = name + "." + subdomain
+		}
+		jsRecord := map[string]string{"subdomain": name, "type": record.Type, "ttl": record.TTL}
+		for k, v := range record.Values {
+			jsRecord["value_"+k] = v
+		}
+		rrset, err := parsing.ParseRecordRequest(jsRecord, username)
+		if err != nil {
+			return nil, err
+		}
+		rrsets = append(rrsets, rrset)
+	}
+	return rrsets, nil
+}
+
+// presetConflict checks whether rrset can be added to the zone. CNAME records
+// can't coexist with other records, so PowerDNS would refuse the whole PATCH.
+func presetConflict(zone *powerdns.Zone, rrset *powerdns.RRset) *PresetConflict {
+	for _, existing := range zone.RRsets {
+		if !strings.EqualFold(*existing.Name, *rrset.Name) || len(existing.Records) == 0 {
+			continue
+		}
+		isCNAME, existingCNAME := *rrset.Type == powerdns.RRTypeCNAME, *existing.Type == powerdns.RRTypeCNAME
+		conflict := &PresetConflict{Name: *rrset.Name, Type: string(*rrset.Type)}
+		switch {
+		case isCNAME && existingCNAME && *existing.Records[0].Content != *rrset.Records[0].Content:
+			conflict.Code = CodeTooManyRecords
+			conflict.Error = fmt.Sprintf("Error: a name is only allowed to have one CNAME record, and %s already has one", *rrset.Name)
+			return conflict
+		case isCNAME != existingCNAME:
+			conflict.Code = CodeCNAMEConflict
+			conflict.Error = fmt.Sprintf("Error: can't create record for %s: CNAME records aren't allowed to coexist with other records", *rrset.Name)
+			return conflict
+		}
+	}
+	return nil
+}
+
+// ApplyPreset adds a preset's records to a subdomain of the user's zone, all
+// in one PATCH. Records that are already there are skipped.
+func (rs RecordService) ApplyPreset(ctx context.Context, username string, name string, subdomain string) (*PresetResult, *HTTPError) {
+	preset := findPreset(name)
+	if preset == nil {
+		return nil, newHTTPError(http.StatusNotFound, fmt.Errorf("Error: there's no preset called %s", name))
+	}
+	if subdomain == "" {
+		subdomain = "@"
+	}
+	rrsets, err := presetRRsets(preset, subdomain, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, err)
+	}
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	result := &PresetResult{Added: []ExportedRecord{}, Conflicts: []PresetConflict{}}
+	for _, rrset := range rrsets {
+		if conflict := presetConflict(zone, rrset); conflict != nil {
+			result.Conflicts = append(result.Conflicts, *conflict)
+		}
+	}
+	if len(result.Conflicts) > 0 {
+		return result, nil
+	}
+	before := copyRRsets(zone.RRsets)
+	for _, rrset := range rrsets {
+		if zoneHas(zone, rrset) {
+			continue
+		}
+		zoneAdd(zone, rrset)
+		result.Added = append(result.Added, exportRRsets([]powerdns.RRset{*rrset}, username)...)
+	}
+	if len(result.Added) == 0 {
+		return result, nil
+	}
+	err = rs.updateZone(ctx, username, zone)
+	if err != nil {
+		return nil, patchError(zone.RRsets, err)
+	}
+	rs.recordChange(ctx, username, "preset", before, zone.RRsets)
+	return result, nil
+}
+
+// zoneHas checks if the zone already has rrset's record
+func zoneHas(zone *powerdns.Zone, rrset *powerdns.RRset) bool {
+	idx, err := findRRsetIndex(zone, *rrset.Name, *rrset.Type)
+	if err != nil {
+		return false
+	}
+	for _, record := range zone.RRsets[idx].Records {
+		if *record.Content == *rrset.Records[0].Content {
+			return true
+		}
+	}
+	return false
+}
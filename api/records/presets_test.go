@@ -0,0 +1,91 @@
+package records_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllPresetsApply(t *testing.T) {
+	for _, preset := range records.Presets {
+		rs, _, ctx, username := setupLocal(t)
+		result, err := rs.ApplyPreset(ctx, username, preset.Name, "")
+		if err != nil {
+			t.Fatalf("%s: %s", preset.Name, err)
+		}
+		assert.Equal(t, len(preset.Records), len(result.Added), preset.Name)
+		assert.Equal(t, []records.PresetConflict{}, result.Conflicts)
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	result, err := rs.ApplyPreset(ctx, username, "email", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "shop", Type: "MX", TTL: 3600, Content: "10 mail.example.com."},
+		{Name: "shop", Type: "TXT", TTL: 3600, Content: "\"v=spf1 mx -all\""},
+		{Name: "default._domainkey.shop", Type: "TXT", TTL: 3600, Content: "\"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=\""},
+		{Name: "_dmarc.shop", Type: "TXT", TTL: 3600, Content: "\"v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com\""},
+	}, result.Added)
+
+	// applying it again doesn't add anything
+	result, err = rs.ApplyPreset(ctx, username, "email", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{}, result.Added)
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SOA + 4
+	assert.Equal(t, 5, len(exported))
+
+	_, err = rs.ApplyPreset(ctx, username, "carrier-pigeon", "")
+	assert.Equal(t, http.StatusNotFound, err.Code)
+	_, err = rs.ApplyPreset(ctx, username, "email", "not a name")
+	assert.Equal(t, http.StatusBadRequest, err.Code)
+}
+
+func TestApplyPresetCNAMEConflict(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	err := rs.CreateRecord(ctx, username, map[string]string{"subdomain": "blog", "type": "CNAME", "ttl": "60", "value_Target": "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www.blog", "type": "A", "ttl": "60", "value_A": "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := rs.ApplyPreset(ctx, username, "github-pages", "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 9, len(result.Conflicts))
+	assert.Equal(t, records.PresetConflict{
+		Name:  "www.blog." + domain(username),
+		Type:  "CNAME",
+		Code:  records.CodeCNAMEConflict,
+		Error: "Error: can't create record for www.blog." + domain(username) + ": CNAME records aren't allowed to coexist with other records",
+	}, result.Conflicts[8])
+	assert.Equal(t, []records.ExportedRecord{}, result.Added)
+
+	// nothing was written
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(exported))
+}
+
+func TestApplyPresetBackendDown(t *testing.T) {
+	_, backend, ctx, username := setupLocal(t)
+	rs := records.NewRecordService(brokenBackend{backend})
+	_, err := rs.ApplyPreset(ctx, username, "email", "shop")
+	assert.Equal(t, http.StatusInternalServerError, err.Code)
+}
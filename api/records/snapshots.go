@@ -0,0 +1,194 @@
+package records
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+)
+
+// we only keep this many snapshots for each user
+const maxSnapshots = 20
+
+// Snapshot is a copy of a user's whole zone that they saved so that they
+// can go back to it later, for example before trying an experiment
+type Snapshot struct {
+	ID      int64            `json:"id"`
+	Name    string           `json:"name"`
+	Time    time.Time        `json:"time"`
+	Records []ExportedRecord `json:"records"`
+	rrsets  []powerdns.RRset
+}
+
+func (h *History) addSnapshot(ctx context.Context, username string, name string, rrsets []powerdns.RRset) (*Snapshot, error) {
+	data, err := json.Marshal(rrsets)
+	if err != nil {
+		return nil, err
+	}
+	now := h.now()
+	result, err := h.db.ExecContext(ctx, "INSERT INTO snapshots (username, name, rrsets, created_at) VALUES (?, ?, ?, ?)",
+		username, name, string(data), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		ID:      id,
+		Name:    name,
+		Time:    time.Unix(now.Unix(), 0).UTC(),
+		Records: exportRRsets(rrsets, username),
+		rrsets:  rrsets,
+	}, nil
+}
+
+func (h *History) countSnapshots(ctx context.Context, username string) (int, error) {
+	var count int
+	err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM snapshots WHERE username = ?", username).Scan(&count)
+	return count, err
+}
+
+// listSnapshots returns the user's snapshots, newest first
+func (h *History) listSnapshots(ctx context.Context, username string) ([]Snapshot, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, name, rrsets, created_at FROM snapshots WHERE username = ? ORDER BY id DESC", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows, username)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+func (h *History) getSnapshot(ctx context.Context, username string, id int64) (*Snapshot, error) {
+	row := h.db.QueryRowContext(ctx, "SELECT id, name, rrsets, created_at FROM snapshots WHERE username = ? AND id = ?", username, id)
+	return scanSnapshot(row, username)
+}
+
+func (h *History) deleteSnapshot(ctx context.Context, username string, id int64) (bool, error) {
+	result, err := h.db.ExecContext(ctx, "DELETE FROM snapshots WHERE username = ? AND id = ?", username, id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+func scanSnapshot(row interface{ Scan(...any) error }, username string) (*Snapshot, error) {
+	var snapshot Snapshot
+	var data string
+	var createdAt int64
+	err := row.Scan(&snapshot.ID, &snapshot.Name, &data, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Time = time.Unix(createdAt, 0).UTC()
+	err = json.Unmarshal([]byte(data), &snapshot.rrsets)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Records = exportRRsets(snapshot.rrsets, username)
+	return &snapshot, nil
+}
+
+var errSnapshotsDisabled = fmt.Errorf("snapshots aren't enabled on this server")
+
+// SaveSnapshot saves a copy of the user's zone. The records we manage
+// ourselves (like the SOA) aren't in it.
+func (rs RecordService) SaveSnapshot(ctx context.Context, username string, name string) (*Snapshot, *HTTPError) {
+	if rs.history == nil {
+		return nil, newHTTPError(http.StatusNotImplemented, errSnapshotsDisabled)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Errorf("Error: your snapshot needs a name"))
+	}
+	if len(name) > 100 {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Errorf("Error: snapshot names can be at most 100 characters"))
+	}
+	count, err := rs.history.countSnapshots(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	if count >= maxSnapshots {
+		return nil, newHTTPError(http.StatusBadRequest, fmt.Errorf("Error: you can only have %d snapshots, delete one first", maxSnapshots))
+	}
+	zone, err := rs.getOrCreateZone(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	rrsets := []powerdns.RRset{}
+	for _, rrset := range copyRRsets(zone.RRsets) {
+		if !managedTypes[*rrset.Type] {
+			rrsets = append(rrsets, rrset)
+		}
+	}
+	snapshot, err := rs.history.addSnapshot(ctx, username, name, rrsets)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	return snapshot, nil
+}
+
+func (rs RecordService) GetSnapshots(ctx context.Context, username string) ([]Snapshot, *HTTPError) {
+	if rs.history == nil {
+		return nil, newHTTPError(http.StatusNotImplemented, errSnapshotsDisabled)
+	}
+	snapshots, err := rs.history.listSnapshots(ctx, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot makes the user's zone exactly like the snapshot, in one
+// PATCH. With dryRun it only returns what it would change, which is how we
+// diff a snapshot against the live zone.
+func (rs RecordService) RestoreSnapshot(ctx context.Context, username string, id int64, dryRun bool) (*ZonePlan, *HTTPError) {
+	if rs.history == nil {
+		return nil, newHTTPError(http.StatusNotImplemented, errSnapshotsDisabled)
+	}
+	snapshot, err := rs.history.getSnapshot(ctx, username, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, newHTTPError(http.StatusNotFound, fmt.Errorf("Error: snapshot %d not found", id))
+	}
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, err)
+	}
+	desiredSets := map[rrsetKey]*powerdns.RRset{}
+	order := []rrsetKey{}
+	for i, rrset := range snapshot.rrsets {
+		k := rrsetKey{strings.ToLower(*rrset.Name), *rrset.Type}
+		desiredSets[k] = &snapshot.rrsets[i]
+		order = append(order, k)
+	}
+	return rs.syncRRsets(ctx, username, "restore", desiredSets, order, dryRun)
+}
+
+func (rs RecordService) DeleteSnapshot(ctx context.Context, username string, id int64) *HTTPError {
+	if rs.history == nil {
+		return newHTTPError(http.StatusNotImplemented, errSnapshotsDisabled)
+	}
+	deleted, err := rs.history.deleteSnapshot(ctx, username, id)
+	if err != nil {
+		return newHTTPError(http.StatusInternalServerError, err)
+	}
+	if !deleted {
+		return newHTTPError(http.StatusNotFound, fmt.Errorf("Error: snapshot %d not found", id))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package records_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshots(t *testing.T) {
+	rs, ctx, username := setupHistory(t)
+	_, err := rs.ImportRecords(ctx, username, "www 60 A 1.2.3.4\n@ 60 TXT \"hi\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := rs.SaveSnapshot(ctx, username, " known good ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "known good", snapshot.Name)
+	// no SOA
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+		{Name: "@", Type: "TXT", TTL: 60, Content: "\"hi\""},
+	}, snapshot.Records)
+
+	// break everything
+	err = rs.DeleteAllRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "www", "type": "A", "ttl": "300", "value_A": "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.CreateRecord(ctx, username, map[string]string{"subdomain": "oops", "type": "CNAME", "ttl": "60", "value_Target": "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the diff is what restoring would do
+	plan, err := rs.RestoreSnapshot(ctx, username, snapshot.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &records.ZonePlan{
+		Adds:    []records.ExportedRecord{{Name: "@", Type: "TXT", TTL: 60, Content: "\"hi\""}},
+		Removes: []records.ExportedRecord{{Name: "oops", Type: "CNAME", TTL: 60, Content: "example.com."}},
+		Changes: []records.RecordChange{{
+			From: records.ExportedRecord{Name: "www", Type: "A", TTL: 300, Content: "1.2.3.4"},
+			To:   records.ExportedRecord{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+		}},
+	}, plan)
+
+	plan, err = rs.RestoreSnapshot(ctx, username, snapshot.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, plan.Applied)
+	exported, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{
+		{Name: "@", Type: "TXT", TTL: 60, Content: "\"hi\""},
+		{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+	}, exported[1:])
+
+	// restoring is in the history, so it can be undone
+	changes, err := rs.GetHistory(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "restore", changes[0].Action)
+
+	snapshots, err := rs.GetSnapshots(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(snapshots))
+	err = rs.DeleteSnapshot(ctx, username, snapshot.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.DeleteSnapshot(ctx, username, snapshot.ID)
+	assert.Equal(t, http.StatusNotFound, err.Code)
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	rs, ctx, username := setupHistory(t)
+	_, err := rs.SaveSnapshot(ctx, username, "")
+	assert.Equal(t, "Error: your snapshot needs a name", err.Error())
+	for i := 0; i < 20; i++ {
+		_, err = rs.SaveSnapshot(ctx, username, "snapshot")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = rs.SaveSnapshot(ctx, username, "one too many")
+	assert.Equal(t, "Error: you can only have 20 snapshots, delete one first", err.Error())
+
+	// other people's snapshots aren't there
+	snapshots, err := rs.GetSnapshots(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rs.RestoreSnapshot(ctx, generateUsername(), snapshots[0].ID, false)
+	assert.Equal(t, http.StatusNotFound, err.Code)
+
+	// without history, there are no snapshots
+	rs, _, _, _ = setupLocal(t)
+	_, err = rs.SaveSnapshot(ctx, username, "snapshot")
+	assert.Equal(t, http.StatusNotImplemented, err.Code)
+}
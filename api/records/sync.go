@@ -0,0 +1,210 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/parsing"
+)
+
+// ZonePlan is what SyncZone needs to do to get from the current zone to the
+// desired one. A change is a record whose content stayed the same but whose
+// TTL didn't.
+type ZonePlan struct {
+	Adds    []ExportedRecord `json:"adds"`
+	Removes []ExportedRecord `json:"removes"`
+	Changes []RecordChange   `json:"changes"`
+	Applied bool             `json:"applied"`
+}
+
+type RecordChange struct {
+	From ExportedRecord `json:"from"`
+	To   ExportedRecord `json:"to"`
+}
+
+type rrsetKey struct {
+	name string
+	typ  powerdns.RRType
+}
+
+// managedTypes are record types we take care of ourselves, so they're left
+// alone when syncing
+var managedTypes = map[powerdns.RRType]bool{
+	powerdns.RRTypeSOA: true,
+	"DNSKEY":           true,
+	"DS":               true,
+}
+
+// SyncZone makes the user's zone have exactly the desired records (in the
+// same format as the "record" field of Record). With dryRun it only returns
+// the plan, otherwise it applies all of it in one PATCH.
+func (rs RecordService) SyncZone(ctx context.Context, username string, desired []map[string]string, dryRun bool) (*ZonePlan, *HTTPError) {
+	desiredSets, order, err := desiredRRsets(desired, username)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, err)
+	}
+	return rs.syncRRsets(ctx, username, "sync", desiredSets, order, dryRun)
+}
+
+// syncRRsets makes the zone's RRsets match desiredSets. order is the order
+// of the new RRsets, so that the plan is in the same order as the input.
+func (rs RecordService) syncRRsets(ctx context.Context, username string, action string, desiredSets map[rrsetKey]*powerdns.RRset, order []rrsetKey, dryRun bool) (*ZonePlan, *HTTPError) {
+	unlock := rs.lockZone(username)
+	defer unlock()
+	zone, httpErr := rs.getZoneForEdit(ctx, username)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	currentSets := map[rrsetKey]*powerdns.RRset{}
+	keys := []rrsetKey{}
+	for i, rrset := range zone.RRsets {
+		k := rrsetKey{strings.ToLower(*rrset.Name), *rrset.Type}
+		if managedTypes[k.typ] {
+			continue
+		}
+		currentSets[k] = &zone.RRsets[i]
+		keys = append(keys, k)
+	}
+	for _, k := range order {
+		if _, ok := currentSets[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	plan := &ZonePlan{Adds: []ExportedRecord{}, Removes: []ExportedRecord{}, Changes: []RecordChange{}}
+	patch := []powerdns.RRset{}
+	for _, k := range keys {
+		current, want := currentSets[k], desiredSets[k]
+		if !diffRRset(plan, username, current, want) {
+			continue
+		}
+		if want == nil {
+			deleteType := powerdns.ChangeTypeDelete
+			patch = append(patch, powerdns.RRset{Name: current.Name, Type: current.Type, ChangeType: &deleteType, Records: []powerdns.Record{}})
+			continue
+		}
+		replace := powerdns.ChangeTypeReplace
+		want.ChangeType = &replace
+		patch = append(patch, *want)
+	}
+	if dryRun || len(patch) == 0 {
+		return plan, nil
+	}
+	err := rs.backend.PatchRRsets(ctx, zoneName(username), patch)
+	if err != nil {
+		return nil, patchError(patch, err)
+	}
+	rs.recordChange(ctx, username, action, zone.RRsets, patch)
+	plan.Applied = true
+	return plan, nil
+}
+
+// patchError is the API error for a failed PatchRRsets, translated for the
+// RRset it's about. Like updateError, only the errors we know about are the
+// user's fault.
+func patchError(patch []powerdns.RRset, err error) *HTTPError {
+	for _, rrset := range patch {
+		if len(rrset.Records) > 0 && errorIsAbout(err, *rrset.Name, *rrset.Type, "") {
+			return updateError(&rrset, err)
+		}
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return newHTTPError(http.StatusBadRequest, err)
+	}
+	return newHTTPError(http.StatusInternalServerError, err)
+}
+
+// desiredRRsets parses the desired records and groups them into RRsets
+func desiredRRsets(desired []map[string]string, username string) (map[rrsetKey]*powerdns.RRset, []rrsetKey, error) {
+	rrsets := map[rrsetKey]*powerdns.RRset{}
+	order := []rrsetKey{}
+	for i, record := range desired {
+		jsRecord := map[string]string{}
+		for k, v := range record {
+			// these are in GetRecords' output, but they're not part of
+			// the record request
+			if k != "content" && k != "domain_name" && k != "strings" {
+				jsRecord[k] = v
+			}
+		}
+		if managedTypes[powerdns.RRType(jsRecord["type"])] {
+			continue
+		}
+		rrset, err := parsing.ParseRecordRequest(jsRecord, username)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error: record %d: %w", i+1, err)
+		}
+		k := rrsetKey{*rrset.Name, *rrset.Type}
+		existing, ok := rrsets[k]
+		if !ok {
+			rrsets[k] = rrset
+			order = append(order, k)
+			continue
+		}
+		if *existing.TTL != *rrset.TTL {
+			return nil, nil, fmt.Errorf("Error: record %d: all the %s records for %s need to have the same TTL", i+1, k.typ, k.name)
+		}
+		content := *rrset.Records[0].Content
+		for _, r := range existing.Records {
+			if *r.Content == content {
+				return nil, nil, fmt.Errorf("Error: record %d: there's already a record with name %s, type %s, and content %s", i+1, k.name, k.typ, content)
+			}
+		}
+		existing.Records = append(existing.Records, rrset.Records...)
+	}
+	return rrsets, order, nil
+}
+
+// diffRRset adds the differences between two versions of an RRset to the
+// plan, and says whether there were any
+func diffRRset(plan *ZonePlan, username string, current *powerdns.RRset, want *powerdns.RRset) bool {
+	exported := func(rrset *powerdns.RRset, content string) ExportedRecord {
+		return ExportedRecord{Name: relativeName(*rrset.Name, username), Type: string(*rrset.Type), TTL: *rrset.TTL, Content: content}
+	}
+	has := func(rrset *powerdns.RRset, content string) bool {
+		if rrset == nil {
+			return false
+		}
+		for _, r := range rrset.Records {
+			if *r.Content == content {
+				return true
+			}
+		}
+		return false
+	}
+	changed := false
+	if current != nil {
+		for _, r := range current.Records {
+			switch {
+			case !has(want, *r.Content):
+				plan.Removes = append(plan.Removes, exported(current, *r.Content))
+				changed = true
+			case *current.TTL != *want.TTL:
+				plan.Changes = append(plan.Changes, RecordChange{From: exported(current, *r.Content), To: exported(want, *r.Content)})
+				changed = true
+			}
+		}
+	}
+	if want != nil {
+		for _, r := range want.Records {
+			if !has(current, *r.Content) {
+				plan.Adds = append(plan.Adds, exported(want, *r.Content))
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func relativeName(name string, username string) string {
+	zone := zoneName(username)
+	if strings.EqualFold(name, zone) {
+		return "@"
+	}
+	return strings.TrimSuffix(strings.ToLower(name), "."+zone)
+}
@@ -0,0 +1,139 @@
+package records_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	powerdns "github.com/joeig/go-powerdns/v3"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncZone(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	_, err := rs.ImportRecords(ctx, username, "www 60 A 1.2.3.4\nwww 60 A 5.6.7.8\nold 60 TXT \"bye\"\n@ 60 MX 10 mail.example.com.\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []map[string]string{
+		{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+		{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "2.3.4.5"},
+		{"subdomain": "@", "type": "MX", "ttl": "300", "value_Preference": "10", "value_Mx": "mail.example.com"},
+		{"subdomain": "new", "type": "TXT", "ttl": "60", "value_Txt": "hi"},
+	}
+	expected := &records.ZonePlan{
+		Adds: []records.ExportedRecord{
+			{Name: "www", Type: "A", TTL: 60, Content: "2.3.4.5"},
+			{Name: "new", Type: "TXT", TTL: 60, Content: "\"hi\""},
+		},
+		Removes: []records.ExportedRecord{
+			{Name: "www", Type: "A", TTL: 60, Content: "5.6.7.8"},
+			{Name: "old", Type: "TXT", TTL: 60, Content: "\"bye\""},
+		},
+		Changes: []records.RecordChange{{
+			From: records.ExportedRecord{Name: "@", Type: "MX", TTL: 60, Content: "10 mail.example.com."},
+			To:   records.ExportedRecord{Name: "@", Type: "MX", TTL: 300, Content: "10 mail.example.com."},
+		}},
+	}
+
+	// a dry run doesn't change anything
+	plan, err := rs.SyncZone(ctx, username, desired, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, plan)
+	before, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, len(before))
+
+	plan, err = rs.SyncZone(ctx, username, desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.Applied = true
+	assert.Equal(t, expected, plan)
+	after, err := rs.ExportRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []records.ExportedRecord{
+		after[0],
+		{Name: "@", Type: "MX", TTL: 300, Content: "10 mail.example.com."},
+		{Name: "new", Type: "TXT", TTL: 60, Content: "\"hi\""},
+		{Name: "www", Type: "A", TTL: 60, Content: "1.2.3.4"},
+		{Name: "www", Type: "A", TTL: 60, Content: "2.3.4.5"},
+	}, after)
+
+	// syncing again doesn't do anything, and GetRecords' output (including
+	// the SOA) can be used as the desired state
+	recs, err := rs.GetRecords(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired = []map[string]string{}
+	for _, r := range recs {
+		record := map[string]string{"subdomain": r.Record.Subdomain, "type": r.Record.Type, "ttl": r.Record.TTL, "content": r.Record.Content, "domain_name": r.Record.DomainName}
+		for k, v := range r.Record.Values {
+			record["value_"+k] = v
+		}
+		desired = append(desired, record)
+	}
+	plan, err = rs.SyncZone(ctx, username, desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &records.ZonePlan{Adds: []records.ExportedRecord{}, Removes: []records.ExportedRecord{}, Changes: []records.RecordChange{}}, plan)
+}
+
+func TestSyncZoneErrors(t *testing.T) {
+	rs, _, ctx, username := setupLocal(t)
+	tests := []struct {
+		Desired []map[string]string
+		Error   string
+	}{
+		{
+			[]map[string]string{{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "banana"}},
+			"Error: record 1: Invalid IPv4 address: banana",
+		},
+		{
+			[]map[string]string{
+				{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+				{"subdomain": "www", "type": "A", "ttl": "30", "value_A": "5.6.7.8"},
+			},
+			"Error: record 2: all the A records for www." + domain(username) + " need to have the same TTL",
+		},
+		{
+			[]map[string]string{
+				{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"},
+				{"subdomain": "www", "type": "CNAME", "ttl": "60", "value_Target": "example.com"},
+			},
+			"Error: can't create record for www." + domain(username) + ": CNAME records aren't allowed to coexist with other records",
+		},
+	}
+	for _, test := range tests {
+		_, err := rs.SyncZone(ctx, username, test.Desired, false)
+		assert.Equal(t, 400, err.Code)
+		assert.Equal(t, test.Error, err.Error())
+	}
+}
+
+// brokenBackend is a backend where every edit fails, like PowerDNS when it's down
+type brokenBackend struct {
+	*records.LocalBackend
+}
+
+func (b brokenBackend) PatchRRsets(ctx context.Context, name string, rrsets []powerdns.RRset) error {
+	return errors.New("connection refused")
+}
+
+func TestSyncZoneBackendDown(t *testing.T) {
+	_, backend, ctx, username := setupLocal(t)
+	rs := records.NewRecordService(brokenBackend{backend})
+	_, err := rs.SyncZone(ctx, username, []map[string]string{{"subdomain": "www", "type": "A", "ttl": "60", "value_A": "1.2.3.4"}}, false)
+	assert.Equal(t, http.StatusInternalServerError, err.Code)
+	assert.Equal(t, records.CodeInternalError, err.ErrorCode)
+}
@@ -0,0 +1,118 @@
+package records
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// findWildcard finds the wildcard record (like "*.orange.messwithdns.com.")
+// in zone that matches name, if there is one. Wildcards only match names that
+// don't exist, and only below the closest name that does exist.
+func findWildcard(zone string, name string, hasRecords func(string) bool, hasDescendants func(string) bool) string {
+	for name != zone {
+		next, end := dns.NextLabel(name, 0)
+		if end {
+			return ""
+		}
+		name = name[next:]
+		wildcard := "*." + name
+		if hasRecords(wildcard) {
+			return wildcard
+		}
+		if hasRecords(name) || hasDescendants(name) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// how long we remember a zone's names for Wildcard. Wildcard is only for
+// grouping responses for rate limiting, so it's fine if it's a bit behind
+// the user's edits.
+const wildcardCacheTTL = time.Minute
+
+type wildcardCache struct {
+	mu          sync.Mutex
+	zones       map[string]cachedNames
+	lastCleanup time.Time
+}
+
+// cachedNames is every name in a zone that has records
+type cachedNames struct {
+	names   map[string]bool
+	fetched time.Time
+}
+
+func newWildcardCache() *wildcardCache {
+	return &wildcardCache{zones: map[string]cachedNames{}}
+}
+
+// Wildcard is the wildcard record (like "*.orange.messwithdns.com.") that
+// answers queries for name, or "" if name isn't covered by a wildcard. It
+// works with any backend by looking at the zone's records, which get cached
+// for a minute, since it gets called while we're answering DNS queries.
+func (rs RecordService) Wildcard(name string) string {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !strings.HasSuffix(name, "."+TLD) {
+		return ""
+	}
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, "."+TLD))
+	zone := zoneName(labels[len(labels)-1])
+	names := rs.zoneNames(zone)
+	hasRecords := func(name string) bool {
+		return names[name]
+	}
+	hasDescendants := func(name string) bool {
+		for other := range names {
+			if strings.HasSuffix(other, "."+name) {
+				return true
+			}
+		}
+		return false
+	}
+	if hasRecords(name) || hasDescendants(name) {
+		return ""
+	}
+	return findWildcard(zone, name, hasRecords, hasDescendants)
+}
+
+// zoneNames gets the names in the zone from the cache, or from the backend if
+// they aren't cached
+func (rs RecordService) zoneNames(zone string) map[string]bool {
+	cache := rs.wildcards
+	now := time.Now()
+	cache.mu.Lock()
+	cached, ok := cache.zones[zone]
+	cache.mu.Unlock()
+	if ok && now.Sub(cached.fetched) < wildcardCacheTTL {
+		return cached.names
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	names := map[string]bool{}
+	// if we can't get the zone we cache that too, so that we don't ask again
+	// for every query
+	if z, err := rs.backend.GetZone(ctx, zone); err == nil {
+		for _, rrset := range z.RRsets {
+			names[strings.ToLower(*rrset.Name)] = true
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if now.Sub(cache.lastCleanup) > wildcardCacheTTL {
+		cache.lastCleanup = now
+		for zone, cached := range cache.zones {
+			if now.Sub(cached.fetched) >= wildcardCacheTTL {
+				delete(cache.zones, zone)
+			}
+		}
+	}
+	cache.zones[zone] = cachedNames{names: names, fetched: now}
+	return names
+}
@@ -0,0 +1,68 @@
+package records_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jvns/mess-with-dns/ratelimit"
+	"github.com/jvns/mess-with-dns/records"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePowerDNS serves one zone from PowerDNS's zone API, and counts how many
+// times it gets asked for it
+func fakePowerDNS(t *testing.T, zone string, names []string) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/zones/"+zone) {
+			http.Error(w, `{"error": "Could not find domain"}`, http.StatusNotFound)
+			return
+		}
+		requests++
+		rrsets := []string{}
+		for _, name := range names {
+			rrsets = append(rrsets, fmt.Sprintf(`{"name": %q, "type": "A", "ttl": 60, "records": [{"content": "1.2.3.4", "disabled": false}]}`, name))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name": %q, "kind": "Native", "rrsets": [%s]}`, zone, strings.Join(rrsets, ","))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestWildcardPowerDNS(t *testing.T) {
+	username := generateUsername()
+	zone := domain(username)
+	server, requests := fakePowerDNS(t, zone, []string{"www." + zone, "*.wild." + zone, "a.b." + zone})
+	rs := records.Init(server.URL, "not-a-secret")
+
+	assert.Equal(t, "*.wild."+zone, rs.Wildcard("anything.wild."+zone))
+	assert.Equal(t, "*.wild."+zone, rs.Wildcard("ANYTHING.else.wild."+zone))
+	assert.Equal(t, "", rs.Wildcard("www."+zone))
+	// b exists because it has a name under it, so no wildcard
+	assert.Equal(t, "", rs.Wildcard("c.b."+zone))
+	assert.Equal(t, "", rs.Wildcard("www.example.com."))
+	assert.Equal(t, "", rs.Wildcard("anything.wild."+domain(generateUsername())))
+	// we don't ask PowerDNS again for every query
+	assert.Equal(t, 1, *requests)
+
+	// unsigned answers from the same wildcard go in the same RRL bucket
+	rrl := ratelimit.NewRRL(ratelimit.RRLConfig{ResponsesPerSecond: 1})
+	rrl.SetWildcards(rs.Wildcard)
+	answer := func(name string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		rr, _ := dns.NewRR(name + " 60 IN A 1.2.3.4")
+		m.Answer = []dns.RR{rr}
+		return m
+	}
+	victim := net.ParseIP("192.0.2.1")
+	assert.Equal(t, ratelimit.Send, rrl.Check(victim, answer("a.wild."+zone)))
+	assert.Equal(t, ratelimit.Drop, rrl.Check(victim, answer("b.wild."+zone)))
+	assert.Equal(t, ratelimit.Send, rrl.Check(victim, answer("www."+zone)))
+}
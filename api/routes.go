@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/jvns/mess-with-dns/metrics"
+	"github.com/jvns/mess-with-dns/records"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -15,23 +17,85 @@ func createRoutes(handle *handler) http.Handler {
 		username := r.Context().Value("username").(string)
 		getRecords(username, handle.rs, w, r)
 	}))
-	mux.Handle("DELETE /records/{record_id}", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("DELETE /records/{record_id}", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		recordID := r.PathValue("record_id")
 		username := r.Context().Value("username").(string)
 		deleteRecord(username, recordID, handle.rs, w, r)
-	}))
-	mux.Handle("DELETE /records", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.Handle("DELETE /records", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		username := r.Context().Value("username").(string)
 		deleteAllRecords(username, handle.rs, w, r)
-	}))
-	mux.Handle("POST /records/{record_id}", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.Handle("POST /records/{record_id}", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		recordID := r.PathValue("record_id")
 		username := r.Context().Value("username").(string)
 		updateRecord(username, recordID, handle.rs, w, r)
-	}))
-	mux.Handle("POST /records", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.Handle("POST /records", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		username := r.Context().Value("username").(string)
 		createRecord(username, handle.rs, w, r)
+	})))
+	mux.Handle("GET /records/lint", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		lintRecords(username, handle.rs, w, r)
+	}))
+	mux.Handle("GET /records/history", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		getHistory(username, handle.rs, w, r)
+	}))
+	mux.Handle("POST /records/history/{change_id}/revert", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		changeID := r.PathValue("change_id")
+		username := r.Context().Value("username").(string)
+		revertChange(username, changeID, handle.rs, w, r)
+	})))
+	mux.Handle("GET /presets", addBaseMiddlewares(getPresets))
+	mux.Handle("POST /presets/{preset_name}/apply", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		presetName := r.PathValue("preset_name")
+		username := r.Context().Value("username").(string)
+		applyPreset(username, presetName, handle.rs, w, r)
+	})))
+	mux.Handle("GET /snapshots", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		getSnapshots(username, handle.rs, w, r)
+	}))
+	mux.Handle("POST /snapshots", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		saveSnapshot(username, handle.rs, w, r)
+	}))
+	mux.Handle("GET /snapshots/{snapshot_id}/diff", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		snapshotID := r.PathValue("snapshot_id")
+		username := r.Context().Value("username").(string)
+		restoreSnapshot(username, snapshotID, true, handle.rs, w, r)
+	}))
+	mux.Handle("POST /snapshots/{snapshot_id}/restore", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		snapshotID := r.PathValue("snapshot_id")
+		username := r.Context().Value("username").(string)
+		restoreSnapshot(username, snapshotID, false, handle.rs, w, r)
+	})))
+	mux.Handle("DELETE /snapshots/{snapshot_id}", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		snapshotID := r.PathValue("snapshot_id")
+		username := r.Context().Value("username").(string)
+		deleteSnapshot(username, snapshotID, handle.rs, w, r)
+	}))
+	mux.Handle("GET /records/export", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		exportRecords(username, handle.rs, w, r)
+	}))
+	mux.Handle("POST /records/import", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		importRecords(username, handle.rs, w, r)
+	})))
+	mux.Handle("PUT /zone", handle.addMiddlewares(ifMatchMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		syncZone(username, handle.rs, w, r)
+	})))
+	mux.Handle("GET /dnssec", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		getDNSSEC(username, handle.rs, w, r)
+	}))
+	mux.Handle("POST /dnssec", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		setDNSSEC(username, handle.rs, w, r)
 	}))
 	mux.Handle("GET /requests", handle.addMiddlewares(func(w http.ResponseWriter, r *http.Request) {
 		username := r.Context().Value("username").(string)
@@ -48,6 +112,8 @@ func createRoutes(handle *handler) http.Handler {
 		}
 		streamRequests(handle.logger, username, w, r)
 	}))
+	mux.Handle("GET /dns-query", addBaseMiddlewares(handle.dnsQuery))
+	mux.Handle("POST /dns-query", addBaseMiddlewares(handle.dnsQuery))
 	mux.Handle("GET /login/", addBaseMiddlewares(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
 		loginRandom(handle.userService, handle.rs, w, r)
@@ -59,7 +125,24 @@ func createRoutes(handle *handler) http.Handler {
 		http.ServeFile(w, r, handle.workdir+"/frontend/"+r.URL.Path)
 	}))
 
-	return mux
+	routes := metrics.InstrumentRoutes(mux)
+	if handle.behindProxy {
+		routes = proxyMiddleware(routes)
+	}
+	return routes
+}
+
+// ifMatchMiddleware is for routes that edit records: it makes the edit fail
+// if the zone has changed since the client got the ETag in If-Match
+func ifMatchMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withSerial, err := withIfMatch(r)
+		if err != nil {
+			returnError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		next(w, withSerial)
+	}
 }
 
 func addBaseMiddlewares(handleFunc func(w http.ResponseWriter, r *http.Request)) http.Handler {
@@ -77,8 +160,10 @@ func (handle *handler) corsLoginMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// CORS
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, If-Match")
+		// so that cross-origin clients can read the ETag to send in If-Match
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		username, _ := handle.userService.ReadSessionUsername(r)
 
@@ -92,6 +177,8 @@ func (handle *handler) corsLoginMiddleware(next http.Handler) http.Handler {
 
 		// check that user is logged in
 		ctx := context.WithValue(r.Context(), "username", username)
+		// so that we know where edits came from in the history
+		ctx = records.WithSourceIP(ctx, clientIP(r))
 		r = r.WithContext(ctx)
 		logMsg(r, fmt.Sprintf("%s %s (%s)", r.Method, r.URL.Path, username))
 		if username == "" {
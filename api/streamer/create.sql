@@ -6,6 +6,13 @@ CREATE TABLE IF NOT EXISTS dns_requests
   src_ip VARCHAR(20) NOT NULL,
   src_host VARCHAR(255) NOT NULL,
   response TEXT NOT NULL,
+  query TEXT NOT NULL DEFAULT '',
+  transport VARCHAR(10) NOT NULL DEFAULT '',
+  request_size INTEGER NOT NULL DEFAULT 0,
+  response_size INTEGER NOT NULL DEFAULT 0,
+  retried_tcp BOOLEAN NOT NULL DEFAULT 0,
+  upstream_rtt_us INTEGER NOT NULL DEFAULT 0,
+  alias TEXT NOT NULL DEFAULT '',
   created_at TIMESTAMP NOT NULL DEFAULT (strftime('%s','now'))
 );
 
@@ -5,8 +5,12 @@ import (
 	"database/sql"
 	_ "embed"
 	"encoding/base64"
+	"encoding/json"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/jvns/mess-with-dns/metrics"
 	"github.com/miekg/dns"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -16,6 +20,18 @@ import (
 //go:embed create.sql
 var create_sql string
 
+// columns that were added to dns_requests after it was first created. They're
+// also in create.sql, so "duplicate column" errors are expected.
+var migrations = []string{
+	"ALTER TABLE dns_requests ADD COLUMN transport VARCHAR(10) NOT NULL DEFAULT ''",
+	"ALTER TABLE dns_requests ADD COLUMN query TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE dns_requests ADD COLUMN request_size INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE dns_requests ADD COLUMN response_size INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE dns_requests ADD COLUMN retried_tcp BOOLEAN NOT NULL DEFAULT 0",
+	"ALTER TABLE dns_requests ADD COLUMN upstream_rtt_us INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE dns_requests ADD COLUMN alias TEXT NOT NULL DEFAULT ''",
+}
+
 func connectDB(dbFile string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", dbFile)
 	if err != nil {
@@ -26,6 +42,12 @@ func connectDB(dbFile string) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, migration := range migrations {
+		_, err = db.Exec(migration)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -72,23 +94,43 @@ func deserializeMsg(encoded string) (*dns.Msg, error) {
 	return msg, nil
 }
 
-func (l *Logger) logRequest(ctx context.Context, response *dns.Msg, src_ip net.IP, src_host string) error {
+func (l *Logger) logRequest(ctx context.Context, query *dns.Msg, response *dns.Msg, src_ip net.IP, src_host string, meta RequestMeta) error {
 	_, span := tracer.Start(ctx, "db.LogRequest")
 	defer span.End()
 	serializedResp, err := serializeMsg(response)
 	if err != nil {
 		return err
 	}
+	// the query is optional
+	serializedQuery := ""
+	if query != nil {
+		serializedQuery, err = serializeMsg(query)
+		if err != nil {
+			return err
+		}
+	}
+	// the ALIAS resolution is JSON, and empty if there wasn't one
+	serializedAlias := ""
+	if meta.Alias != nil {
+		data, err := json.Marshal(meta.Alias)
+		if err != nil {
+			return err
+		}
+		serializedAlias = string(data)
+	}
 	name := response.Question[0].Name
 	subdomain := ExtractSubdomain(name)
-	err = writeToStreams(subdomain, response, src_host, src_ip)
+	err = writeToStreams(subdomain, query, response, src_host, src_ip, meta)
 	if err != nil {
 		return err
 	}
-	_, err = l.db.Exec("INSERT INTO dns_requests (name, subdomain,response, src_ip, src_host) VALUES ($1, $2, $3, $4, $5)", name, subdomain, serializedResp, src_ip.String(), src_host)
+	start := time.Now()
+	_, err = l.db.Exec("INSERT INTO dns_requests (name, subdomain,response, query, src_ip, src_host, transport, request_size, response_size, retried_tcp, upstream_rtt_us, alias) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		name, subdomain, serializedResp, serializedQuery, src_ip.String(), src_host, meta.Transport, meta.RequestSize, meta.ResponseSize, meta.RetriedTCP, meta.UpstreamRTT.Microseconds(), serializedAlias)
 	if err != nil {
 		return err
 	}
+	metrics.RequestInsertDuration.Observe(time.Since(start).Seconds())
 
 	return nil
 }
@@ -107,7 +149,7 @@ func (l *Logger) GetRequests(ctx context.Context, subdomain string) ([]StreamLog
 	_, span := tracer.Start(ctx, "db.GetRequests")
 	span.SetAttributes(attribute.String("subdomain", subdomain))
 	defer span.End()
-	rows, err := l.db.Query("SELECT id, created_at, response, src_ip, src_host FROM dns_requests WHERE subdomain = $1 ORDER BY created_at DESC LIMIT 100", subdomain)
+	rows, err := l.db.Query("SELECT id, created_at, response, query, src_ip, src_host, transport, request_size, response_size, retried_tcp, upstream_rtt_us, alias FROM dns_requests WHERE subdomain = $1 ORDER BY created_at DESC LIMIT 100", subdomain)
 	if err != nil {
 		return nil, err
 	}
@@ -116,10 +158,14 @@ func (l *Logger) GetRequests(ctx context.Context, subdomain string) ([]StreamLog
 		var id int
 		var created_at int32
 		var response []byte
+		var query string
 		var src_ip string
 		var src_host string
+		var meta RequestMeta
+		var upstream_rtt_us int64
+		var alias string
 
-		err = rows.Scan(&id, &created_at, &response, &src_ip, &src_host)
+		err = rows.Scan(&id, &created_at, &response, &query, &src_ip, &src_host, &meta.Transport, &meta.RequestSize, &meta.ResponseSize, &meta.RetriedTCP, &upstream_rtt_us, &alias)
 		if err != nil {
 			return nil, err
 		}
@@ -128,7 +174,23 @@ func (l *Logger) GetRequests(ctx context.Context, subdomain string) ([]StreamLog
 			// TODO: do we need to worry about this?
 			continue
 		}
-		log := responseToStreamLog(int64(created_at), msg, src_host, src_ip)
+		// older requests don't have a query saved
+		var queryMsg *dns.Msg
+		if query != "" {
+			queryMsg, err = deserializeMsg(query)
+			if err != nil {
+				continue
+			}
+		}
+		meta.UpstreamRTT = time.Duration(upstream_rtt_us) * time.Microsecond
+		if alias != "" {
+			meta.Alias = &AliasLog{}
+			err = json.Unmarshal([]byte(alias), meta.Alias)
+			if err != nil {
+				meta.Alias = nil
+			}
+		}
+		log := responseToStreamLog(int64(created_at), queryMsg, msg, src_host, src_ip, meta)
 		logs = append(logs, log)
 	}
 	return logs, nil
@@ -0,0 +1,129 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/proto"
+)
+
+// big enough for a dnstap message with both a query and a response in it
+const maxDnstapFrameSize = 256 * 1024
+
+// listenDnstap listens for Frame Streams connections (like the ones dnsdist's
+// DnstapLogResponseAction makes) and logs the DNS responses it gets sent,
+// so that queries which never go through our Go DNS server still show up
+// in the request log.
+func (l *Logger) listenDnstap(ctx context.Context, address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				fmt.Println("error accepting dnstap connection:", err)
+				continue
+			}
+			go l.readDnstap(ctx, conn)
+		}
+	}()
+	return ln, nil
+}
+
+func (l *Logger) readDnstap(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	reader, err := dnstap.NewReader(conn, &dnstap.ReaderOptions{Bidirectional: true})
+	if err != nil {
+		fmt.Println("error starting dnstap reader:", err)
+		return
+	}
+	buf := make([]byte, maxDnstapFrameSize)
+	for {
+		n, err := reader.ReadFrame(buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fmt.Println("error reading dnstap frame:", err)
+			}
+			return
+		}
+		err = l.logDnstap(ctx, buf[:n])
+		if err != nil {
+			fmt.Println("error logging dnstap message:", err)
+		}
+	}
+}
+
+func (l *Logger) logDnstap(ctx context.Context, frame []byte) error {
+	ctx, span := tracer.Start(ctx, "dnstap.request")
+	defer span.End()
+
+	var dt dnstap.Dnstap
+	err := proto.Unmarshal(frame, &dt)
+	if err != nil {
+		return err
+	}
+	m := dt.GetMessage()
+	// we only care about responses, dnsdist only sends us those anyway
+	if dt.GetType() != dnstap.Dnstap_MESSAGE || m == nil || len(m.GetResponseMessage()) == 0 {
+		return nil
+	}
+	response := new(dns.Msg)
+	err = response.Unpack(m.GetResponseMessage())
+	if err != nil {
+		return err
+	}
+	if len(response.Question) == 0 {
+		return nil
+	}
+	// dnsdist doesn't include the query in response messages, but other
+	// dnstap senders might
+	var query *dns.Msg
+	if len(m.GetQueryMessage()) > 0 {
+		query = new(dns.Msg)
+		err = query.Unpack(m.GetQueryMessage())
+		if err != nil {
+			return err
+		}
+	}
+	remote_addr := net.IP(m.GetQueryAddress())
+	remote_host := lookupHost(ctx, l.ipRanges, remote_addr)
+	meta := RequestMeta{
+		Transport:    dnstapTransport(m.GetSocketProtocol()),
+		RequestSize:  len(m.GetQueryMessage()),
+		ResponseSize: len(m.GetResponseMessage()),
+	}
+
+	span.SetAttributes(attribute.String("dns.remote_addr", remote_addr.String()))
+	span.SetAttributes(attribute.String("dns.remote_host", remote_host))
+	span.SetAttributes(attribute.String("dns.transport", meta.Transport))
+	span.SetAttributes(attribute.Int("dns.answer_count", len(response.Answer)))
+
+	return l.logRequest(ctx, query, response, remote_addr, remote_host, meta)
+}
+
+func dnstapTransport(protocol dnstap.SocketProtocol) string {
+	switch protocol {
+	case dnstap.SocketProtocol_TCP:
+		return "tcp"
+	case dnstap.SocketProtocol_DOT:
+		return "dot"
+	case dnstap.SocketProtocol_DOH:
+		return "doh"
+	}
+	return "udp"
+}
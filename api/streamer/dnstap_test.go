@@ -0,0 +1,114 @@
+package streamer
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/jvns/mess-with-dns/streamer/ip2asn"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func fatalIfErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestLogger(t *testing.T) *Logger {
+	db, err := connectDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Logger{ipRanges: &ip2asn.Ranges{}, db: db}
+}
+
+func dnstapFrame(t *testing.T, response *dns.Msg, src net.IP) []byte {
+	wire, err := response.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := dnstap.Dnstap_MESSAGE
+	msgType := dnstap.Message_CLIENT_RESPONSE
+	family := dnstap.SocketFamily_INET
+	protocol := dnstap.SocketProtocol_UDP
+	frame, err := proto.Marshal(&dnstap.Dnstap{
+		Type: &typ,
+		Message: &dnstap.Message{
+			Type:            &msgType,
+			SocketFamily:    &family,
+			SocketProtocol:  &protocol,
+			QueryAddress:    src.To4(),
+			ResponseMessage: wire,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestDnstap(t *testing.T) {
+	logger := createTestLogger(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := logger.listenDnstap(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := logger.CreateStream("orange")
+	defer stream.Delete()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := dnstap.NewWriter(conn, &dnstap.WriterOptions{Bidirectional: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("www.orange.messwithdns.com.", dns.TypeA)
+	response := new(dns.Msg)
+	response.SetReply(query)
+	rr, _ := dns.NewRR("www.orange.messwithdns.com. 60 IN A 1.2.3.4")
+	response.Answer = []dns.RR{rr}
+	_, err = writer.WriteFrame(dnstapFrame(t, response, net.ParseIP("192.0.2.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the writer flushes the frame. It also waits for the reader to
+	// acknowledge, which it won't do until we've read from the stream.
+	closed := make(chan error)
+	go func() {
+		closed <- writer.Close()
+	}()
+
+	select {
+	case msg := <-stream.Get():
+		var log StreamLog
+		err = json.Unmarshal(msg, &log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "www.orange.messwithdns.com.", log.Request.Name)
+		assert.Equal(t, "192.0.2.1", log.Request.SourceIP)
+		assert.Equal(t, "udp", log.Request.Transport)
+		assert.Equal(t, "1.2.3.4", log.Response.Records[0].Content)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for dnstap message")
+	}
+	fatalIfErr(t, <-closed)
+
+	// the request gets written to the database right after it's streamed
+	assert.Eventually(t, func() bool {
+		logs, err := logger.GetRequests(ctx, "orange")
+		return err == nil && len(logs) == 1 && logs[0].Response.Code == "NOERROR"
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -12,8 +12,6 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 )
 
-// func NewReader(r io.Reader, opt *ReaderOptions) (Reader, error)
-
 type Logger struct {
 	ipRanges *ip2asn.Ranges
 	db       *sql.DB
@@ -35,6 +33,13 @@ func Init(ctx context.Context, workdir string, dbFilename string, dnstapAddress
 		db:       ldb,
 	}
 
+	// dnstap is optional: if we can't listen, we can still log the
+	// requests that go through our own DNS server
+	_, err = logger.listenDnstap(ctx, dnstapAddress)
+	if err != nil {
+		fmt.Printf("could not listen for dnstap on %s: %v\n", dnstapAddress, err)
+	}
+
 	return logger, nil
 }
 
@@ -47,7 +52,22 @@ func getIP(w dns.ResponseWriter) (net.IP, error) {
 	return nil, fmt.Errorf("Needs to be a TCP or UDP address")
 }
 
-func (l *Logger) Log(resp *dns.Msg, w dns.ResponseWriter) error {
+// GetTransport figures out how a query got to us: UDP, TCP, or something
+// fancier if the ResponseWriter knows (like our DoH one)
+func GetTransport(w dns.ResponseWriter) string {
+	if t, ok := w.(interface{ Transport() string }); ok {
+		return t.Transport()
+	}
+	if cs, ok := w.(dns.ConnectionStater); ok && cs.ConnectionState() != nil {
+		return "dot"
+	}
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		return "tcp"
+	}
+	return "udp"
+}
+
+func (l *Logger) Log(query *dns.Msg, resp *dns.Msg, w dns.ResponseWriter, meta RequestMeta) error {
 	ctx := context.Background()
 	ctx, span := tracer.Start(ctx, "dns.request")
 
@@ -56,14 +76,16 @@ func (l *Logger) Log(resp *dns.Msg, w dns.ResponseWriter) error {
 		return err
 	}
 	remote_host := lookupHost(ctx, l.ipRanges, remote_addr)
-
-	// TODO: should we put the query message back? do we care? idk.
+	if meta.Transport == "" {
+		meta.Transport = GetTransport(w)
+	}
 
 	span.SetAttributes(attribute.String("dns.remote_addr", remote_addr.String()))
 	span.SetAttributes(attribute.String("dns.remote_host", remote_host))
+	span.SetAttributes(attribute.String("dns.transport", meta.Transport))
 	span.SetAttributes(attribute.Int("dns.answer_count", len(resp.Answer)))
 
-	err = l.logRequest(ctx, resp, remote_addr, remote_host)
+	err = l.logRequest(ctx, query, resp, remote_addr, remote_host, meta)
 	if err != nil {
 		return fmt.Errorf("could not log request: %v", err)
 	}
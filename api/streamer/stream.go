@@ -2,6 +2,7 @@ package streamer
 
 import (
 	"encoding/json"
+	"github.com/jvns/mess-with-dns/metrics"
 	"github.com/miekg/dns"
 	"math/rand"
 	"net"
@@ -22,6 +23,7 @@ func (l *Logger) CreateStream(subdomain string) Stream {
 	}
 	id := randString(10)
 	streams[subdomain][id] = make(chan []byte)
+	metrics.Streams.Inc()
 	return Stream{id: id, subdomain: subdomain}
 }
 
@@ -38,6 +40,7 @@ func (s *Stream) Delete() {
 	if _, ok := streams[s.subdomain]; ok {
 		close(streams[s.subdomain][s.id])
 		delete(streams[s.subdomain], s.id)
+		metrics.Streams.Dec()
 	}
 }
 
@@ -48,8 +51,8 @@ func (s *Stream) Get() chan []byte {
 	return nil
 }
 
-func writeToStreams(domain string, response *dns.Msg, src_host string, src_ip net.IP) error {
-	streamLog := responseToStreamLog(time.Now().Unix(), response, src_host, src_ip.String())
+func writeToStreams(domain string, query *dns.Msg, response *dns.Msg, src_host string, src_ip net.IP, meta RequestMeta) error {
+	streamLog := responseToStreamLog(time.Now().Unix(), query, response, src_host, src_ip.String(), meta)
 	msg, err := json.Marshal(streamLog)
 	if err != nil {
 		return err
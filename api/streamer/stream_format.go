@@ -1,15 +1,44 @@
 package streamer
 
 import (
+	"fmt"
 	"github.com/miekg/dns"
 	"strings"
+	"time"
 )
 
+// RequestMeta is everything we know about a request that isn't in the DNS
+// messages themselves
+type RequestMeta struct {
+	// udp, tcp, doh or dot
+	Transport    string
+	RequestSize  int
+	ResponseSize int
+	// true if the upstream response was truncated and we retried over TCP
+	RetriedTCP  bool
+	UpstreamRTT time.Duration
+	// how an ALIAS record got resolved, if the query needed one
+	Alias *AliasLog
+}
+
 type StreamRequestLog struct {
 	Name       string `json:"name"`
 	Typ        string `json:"type"`
 	SourceHost string `json:"src_host"`
 	SourceIP   string `json:"src_ip"`
+	Transport  string `json:"transport"`
+	Size       int    `json:"size"`
+	// flags from the query
+	RecursionDesired bool           `json:"rd"`
+	CheckingDisabled bool           `json:"cd"`
+	EDNS             *StreamEDNSLog `json:"edns"`
+}
+
+type StreamEDNSLog struct {
+	UDPSize      uint16 `json:"udp_size"`
+	DNSSECOK     bool   `json:"do"`
+	Cookie       bool   `json:"cookie"`
+	ClientSubnet string `json:"client_subnet,omitempty"`
 }
 
 type StreamRecordLog struct {
@@ -19,8 +48,35 @@ type StreamRecordLog struct {
 }
 
 type StreamResponseLog struct {
-	Code    string            `json:"code"`
-	Records []StreamRecordLog `json:"records"`
+	Code       string            `json:"code"`
+	Records    []StreamRecordLog `json:"records"`
+	Size       int               `json:"size"`
+	RetriedTCP bool              `json:"retried_tcp"`
+	// in milliseconds
+	UpstreamRTT float64   `json:"upstream_rtt"`
+	Alias       *AliasLog `json:"alias,omitempty"`
+}
+
+// AliasLog shows how we flattened an ALIAS record: which name we looked up,
+// who we asked ("zone" means it's one of our zones), and what we got back
+// before renaming the records
+type AliasLog struct {
+	Target   string            `json:"target"`
+	Resolver string            `json:"resolver"`
+	Records  []StreamRecordLog `json:"records"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func NewAliasLog(target string, resolver string, records []dns.RR, err error) *AliasLog {
+	log := &AliasLog{
+		Target:   target,
+		Resolver: resolver,
+		Records:  recordLog(records),
+	}
+	if err != nil {
+		log.Error = err.Error()
+	}
+	return log
 }
 
 type StreamLog struct {
@@ -29,25 +85,59 @@ type StreamLog struct {
 	Response StreamResponseLog `json:"response"`
 }
 
-// dns response to stream log
-func responseToStreamLog(created_at int64, r *dns.Msg, src_host string, src_ip string) StreamLog {
-	var streamLog StreamLog
-	streamLog.Response.Code = dns.RcodeToString[r.Rcode]
+// dns query + response to stream log. The query can be nil (dnstap messages
+// sometimes only have the response), then we use the question from the
+// response instead.
+func responseToStreamLog(created_at int64, query *dns.Msg, r *dns.Msg, src_host string, src_ip string, meta RequestMeta) StreamLog {
+	if query == nil || len(query.Question) == 0 {
+		query = r
+	}
 	return StreamLog{
 		Created: created_at,
 		Request: StreamRequestLog{
-			Name:       r.Question[0].Name,
-			Typ:        dns.TypeToString[r.Question[0].Qtype],
-			SourceHost: src_host,
-			SourceIP:   src_ip,
+			// this keeps the query's exact capitalization, some resolvers
+			// randomize it (0x20 encoding)
+			Name:             query.Question[0].Name,
+			Typ:              dns.TypeToString[query.Question[0].Qtype],
+			SourceHost:       src_host,
+			SourceIP:         src_ip,
+			Transport:        meta.Transport,
+			Size:             meta.RequestSize,
+			RecursionDesired: query.RecursionDesired,
+			CheckingDisabled: query.CheckingDisabled,
+			EDNS:             ednsLog(query),
 		},
 		Response: StreamResponseLog{
-			Code:    dns.RcodeToString[r.Rcode],
-			Records: recordLog(r.Answer),
+			Code:        dns.RcodeToString[r.Rcode],
+			Records:     recordLog(r.Answer),
+			Size:        meta.ResponseSize,
+			RetriedTCP:  meta.RetriedTCP,
+			UpstreamRTT: float64(meta.UpstreamRTT.Microseconds()) / 1000,
+			Alias:       meta.Alias,
 		},
 	}
 }
 
+func ednsLog(m *dns.Msg) *StreamEDNSLog {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	log := &StreamEDNSLog{
+		UDPSize:  opt.UDPSize(),
+		DNSSECOK: opt.Do(),
+	}
+	for _, option := range opt.Option {
+		switch o := option.(type) {
+		case *dns.EDNS0_COOKIE:
+			log.Cookie = true
+		case *dns.EDNS0_SUBNET:
+			log.ClientSubnet = fmt.Sprintf("%s/%d", o.Address, o.SourceNetmask)
+		}
+	}
+	return log
+}
+
 func answerToLog(answer dns.RR) StreamRecordLog {
 	content := answer.String()
 	// this is kind of silly but I think it's an ok way to do it
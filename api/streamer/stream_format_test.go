@@ -0,0 +1,119 @@
+package streamer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func testQuery() *dns.Msg {
+	query := new(dns.Msg)
+	query.SetQuestion("wWw.OrAnGe.messwithdns.com.", dns.TypeA)
+	query.RecursionDesired = false
+	query.CheckingDisabled = true
+	query.SetEdns0(1232, true)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0123456789abcdef"},
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+	)
+	return query
+}
+
+func TestQueryToStreamLog(t *testing.T) {
+	query := testQuery()
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.Question[0].Name = "www.orange.messwithdns.com."
+
+	log := responseToStreamLog(0, query, response, "", "192.0.2.1", RequestMeta{Transport: "udp"})
+	assert.Equal(t, "wWw.OrAnGe.messwithdns.com.", log.Request.Name)
+	assert.False(t, log.Request.RecursionDesired)
+	assert.True(t, log.Request.CheckingDisabled)
+	assert.Equal(t, &StreamEDNSLog{UDPSize: 1232, DNSSECOK: true, Cookie: true, ClientSubnet: "192.0.2.0/24"}, log.Request.EDNS)
+
+	// no query, no EDNS
+	log = responseToStreamLog(0, nil, response, "", "192.0.2.1", RequestMeta{Transport: "udp"})
+	assert.Equal(t, "www.orange.messwithdns.com.", log.Request.Name)
+	assert.Nil(t, log.Request.EDNS)
+}
+
+func TestLogQuery(t *testing.T) {
+	logger := createTestLogger(t)
+	ctx := context.Background()
+	query := testQuery()
+	response := new(dns.Msg)
+	response.SetReply(query)
+	fatalIfErr(t, logger.logRequest(ctx, query, response, net.ParseIP("192.0.2.1"), "", RequestMeta{Transport: "udp"}))
+	fatalIfErr(t, logger.logRequest(ctx, nil, response, net.ParseIP("192.0.2.1"), "", RequestMeta{Transport: "udp"}))
+
+	logs, err := logger.GetRequests(ctx, "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, 2, len(logs))
+	edns := 0
+	for _, log := range logs {
+		assert.Equal(t, "wWw.OrAnGe.messwithdns.com.", log.Request.Name)
+		if log.Request.EDNS != nil {
+			edns++
+			assert.Equal(t, "192.0.2.0/24", log.Request.EDNS.ClientSubnet)
+		}
+	}
+	assert.Equal(t, 1, edns)
+}
+
+func TestLogMeta(t *testing.T) {
+	logger := createTestLogger(t)
+	ctx := context.Background()
+	query := testQuery()
+	response := new(dns.Msg)
+	response.SetReply(query)
+	meta := RequestMeta{
+		Transport:    "tcp",
+		RequestSize:  query.Len(),
+		ResponseSize: response.Len(),
+		RetriedTCP:   true,
+		UpstreamRTT:  1500 * time.Microsecond,
+	}
+	fatalIfErr(t, logger.logRequest(ctx, query, response, net.ParseIP("192.0.2.1"), "", meta))
+
+	logs, err := logger.GetRequests(ctx, "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, "tcp", logs[0].Request.Transport)
+	assert.Equal(t, query.Len(), logs[0].Request.Size)
+	assert.Equal(t, response.Len(), logs[0].Response.Size)
+	assert.True(t, logs[0].Response.RetriedTCP)
+	assert.Equal(t, 1.5, logs[0].Response.UpstreamRTT)
+	assert.Nil(t, logs[0].Response.Alias)
+}
+
+func TestLogAlias(t *testing.T) {
+	logger := createTestLogger(t)
+	ctx := context.Background()
+	query := testQuery()
+	response := new(dns.Msg)
+	response.SetReply(query)
+	a, err := dns.NewRR("example.github.io. 300 IN A 185.199.108.153")
+	fatalIfErr(t, err)
+	meta := RequestMeta{
+		Transport: "udp",
+		Alias:     NewAliasLog("example.github.io.", "1.1.1.1:53", []dns.RR{a}, nil),
+	}
+	fatalIfErr(t, logger.logRequest(ctx, query, response, net.ParseIP("192.0.2.1"), "", meta))
+
+	logs, err := logger.GetRequests(ctx, "orange")
+	fatalIfErr(t, err)
+	assert.Equal(t, &AliasLog{
+		Target:   "example.github.io.",
+		Resolver: "1.1.1.1:53",
+		Records:  []StreamRecordLog{{Typ: "A", TTL: 300, Content: "185.199.108.153"}},
+	}, logs[0].Response.Alias)
+
+	alias := NewAliasLog("example.github.io.", "1.1.1.1:53", nil, fmt.Errorf("i/o timeout"))
+	assert.Equal(t, "i/o timeout", alias.Error)
+	assert.Equal(t, []StreamRecordLog{}, alias.Records)
+}
@@ -9,3 +9,27 @@ export function parseCookies() {
     return cookieObj;
 }
 
+
+// TXT records get split into strings of at most 255 bytes, the same way the
+// server does it (without splitting UTF-8 characters), so that we can show
+// the split before the record is saved
+export function splitTXT(txt: string): string[] {
+    const bytes = new TextEncoder().encode(txt);
+    const decoder = new TextDecoder();
+    const strs = [];
+    let start = 0;
+    while (bytes.length - start > 255) {
+        let end = start + 255;
+        while (end > start + 252 && (bytes[end] & 0xc0) == 0x80) {
+            end--;
+        }
+        strs.push(decoder.decode(bytes.slice(start, end)));
+        start = end;
+    }
+    strs.push(decoder.decode(bytes.slice(start)));
+    return strs;
+}
+
+export function byteLength(s: string): number {
+    return new TextEncoder().encode(s).length;
+}
@@ -1,4 +1,5 @@
 import template from './Experiments.html';
+import { store } from '../store.js';
 export default {
     template: template,
     props: ['domain', 'subdomain'],
@@ -19,9 +20,29 @@ export default {
             `;
         });
     },
+    data: function() {
+        return {
+            store: store,
+            snapshotName: '',
+        };
+    },
     methods: {
         currDomain: function() {
             return this.domain || 'your-domain';
         },
+        saveSnapshot: async function() {
+            await store.saveSnapshot(this.snapshotName);
+            this.snapshotName = '';
+        },
+        restoreSnapshot: async function(snapshot) {
+            if (confirm(`Are you sure you want to replace all your records with the ones from "${snapshot.name}"?`)) {
+                await store.restoreSnapshot(snapshot.id);
+            }
+        },
+        deleteSnapshot: async function(snapshot) {
+            if (confirm(`Are you sure you want to delete "${snapshot.name}"?`)) {
+                await store.deleteSnapshot(snapshot.id);
+            }
+        },
     },
 }
@@ -1,6 +1,7 @@
 import * as schemas from "../schemas.json";
 import template from "./NewRecord.html";
-import { store } from "../store.js";
+import { store, errorMessage } from "../store.js";
+import { splitTXT, byteLength } from "../common.js";
 
 function getSchemaField(type, key) {
     const fields = schemas[type];
@@ -19,6 +20,8 @@ export default {
       schemas: schemas,
       form_data: {},
       form_error: undefined,
+      // things that look wrong about the record we just created
+      form_warnings: [],
     };
   },
   async mounted() {
@@ -39,7 +42,7 @@ export default {
         const input = form.elements[key];
         if (input) {
           input.value = this.record[key];
-        } else if (key != "content" & key != "domain_name") {
+        } else if (key != "content" & key != "domain_name" & key != "strings") {
           console.warn(`No input for key ${key}`);
         }
       }
@@ -64,6 +67,16 @@ export default {
       this.$parent.cancel();
     },
 
+    // how a long TXT record will get split up
+    txtStrings() {
+      if (this.form_data.type != "TXT" || !this.form_data.value_Txt) {
+        return [];
+      }
+      return splitTXT(this.form_data.value_Txt);
+    },
+
+    byteLength: byteLength,
+
     getOptions() {
       if (this.record) {
         return [this.record.type];
@@ -84,9 +97,13 @@ export default {
     updateRecord: async function () {
       const response = await store.updateRecord(this.id, this.form_data);
       if (response.ok) {
+        const { warnings } = await response.json();
+        if (warnings.length > 0) {
+          alert("Saved! But:\n\n" + warnings.map((w) => w.message).join("\n"));
+        }
         this.cancel();
       } else {
-        this.form_error = await response.text();
+        this.form_error = await errorMessage(response);
       }
     },
 
@@ -94,11 +111,12 @@ export default {
       const form = event.target;
       const response = await store.createRecord(this.form_data);
       if (response.status != 200) {
-        this.form_error = await response.text();
+        this.form_error = await errorMessage(response);
         return;
       }
       // clear form
       this.form_error = undefined;
+      this.form_warnings = (await response.json()).warnings;
       form.reset();
       this.setFormState();
       form.blur();
@@ -1,4 +1,5 @@
 import { store } from '../store';
+import { byteLength } from '../common';
 
 import template from './ViewRecord.html';
 
@@ -22,4 +23,8 @@ export default {
         cancel: function() {
             this.clicked = false;
         },
+        // the size of each TXT string, like "255 + 155"
+        stringSizes: function() {
+            return this.record.strings.map(byteLength).join(' + ');
+        },
     }};
@@ -3,25 +3,38 @@ import { parseCookies } from "./common.js";
 
 interface Store {
     records: Record[]
+    // the version of the records we have, so that edits don't overwrite
+    // changes made in another tab
+    etag: string
     requests: Request[]
+    snapshots: Snapshot[]
     ws: WebSocket
 }
 
+interface Snapshot {
+    id: number
+    name: string
+    time: string
+}
+
 interface Record {
     id: string
     subdomain: string
     type: string
     ttl: string
     value: object // e.g. '{A: 1.2.3.4}' but the keys depend on the type
+    strings?: string[] // TXT records only: the 255-byte strings it got split into
 }
 
 export const store: Store = reactive({
     records: [],
+    etag: undefined,
     requests: [],
+    snapshots: [],
     ws: undefined,
 
     async init() {
-        await Promise.all([refreshRecords(), refreshRequests()]);
+        await Promise.all([refreshRecords(), refreshRequests(), refreshSnapshots()]);
         openWebsocket();
     },
     async logout() {
@@ -33,11 +46,11 @@ export const store: Store = reactive({
         const url = '/records/' + record_id;
         const response = await fetch(url, {
             method: 'DELETE',
-            headers: {
-                'Content-Type': 'application/json',
-            },
+            headers: editHeaders(),
         });
-        if (!response.ok) {
+        if (response.status === 409) {
+            alert('Your records were changed in another tab, so the record wasn\'t deleted. Here are the current records.');
+        } else if (!response.ok) {
             alert('Error deleting record');
         }
         refreshRecords();
@@ -70,14 +83,46 @@ export const store: Store = reactive({
     async updateRecord(id, record) {
         const url = '/records/' + id;
         const response = await fetch(url, {
+            method: 'POST',
+            headers: editHeaders(),
+            body: JSON.stringify(record),
+        });
+        refreshRecords();
+        return response; 
+    },
+
+    async saveSnapshot(name) {
+        const response = await fetch('/snapshots', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
-            body: JSON.stringify(record),
+            body: JSON.stringify({ name: name }),
+        });
+        if (!response.ok) {
+            alert(await errorMessage(response));
+        }
+        refreshSnapshots();
+    },
+
+    async restoreSnapshot(id) {
+        const response = await fetch('/snapshots/' + id + '/restore', {
+            method: 'POST',
+            headers: editHeaders(),
         });
+        if (response.status === 409) {
+            alert('Your records were changed in another tab, so the snapshot wasn\'t restored. Here are the current records.');
+        } else if (!response.ok) {
+            alert(await errorMessage(response));
+        }
         refreshRecords();
-        return response; 
+    },
+
+    async deleteSnapshot(id) {
+        await fetch('/snapshots/' + id, {
+            method: 'DELETE',
+        });
+        refreshSnapshots();
     },
 
     async deleteRequests() {
@@ -92,6 +137,33 @@ export const store: Store = reactive({
 async function refreshRecords() {
     const response = await fetch('/records');
     store.records = await response.json();
+    store.etag = response.headers.get('ETag');
+}
+
+// errors from the API look like {"code": "cname_conflict", "message": "..."}
+export async function errorMessage(response: Response): Promise<string> {
+    try {
+        return (await response.json()).message;
+    } catch {
+        return response.statusText;
+    }
+}
+
+function editHeaders() {
+    const headers = {
+        'Content-Type': 'application/json',
+    };
+    if (store.etag) {
+        headers['If-Match'] = store.etag;
+    }
+    return headers;
+}
+
+async function refreshSnapshots() {
+    const response = await fetch('/snapshots');
+    if (response.ok) {
+        store.snapshots = await response.json();
+    }
 }
 
 async function refreshRequests() {
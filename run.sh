@@ -4,6 +4,7 @@ set -eu
 trap 'kill $(jobs -p)' SIGINT SIGTERM
 export REQUEST_DB_FILENAME=requests-dev.sqlite
 export USER_DB_FILENAME=users-dev.sqlite
+export HISTORY_DB_FILENAME=history-dev.sqlite
 # these are "secrets" but in dev mode it doesn't matter, don't use this script
 # in prod
 export HASH_KEY=CgfCQb/b1yLf251DsG9Zo8CN5h6UKP268QZPxR6ddDw=
@@ -13,7 +14,7 @@ export BLOCK_KEY=psYea0IVC59V3kbfMYgWI7AlUmioiNsv9Em1GqksEEE=
 cd pdns/conf_dev || exit 1
 sqlite3 powerdns.sqlite < ../pdns.sql
 rm -f ./pdns.controlsocket
-pdns_server --config-dir=. &
+pdns_server --config-dir=. --resolver="${ALIAS_RESOLVER:-1.1.1.1:53}" &
 cd ../.. || exit 1
 
 ls api/*.go api/go* scripts/* run.sh | entr -r bash scripts/run_go.sh &
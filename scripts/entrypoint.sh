@@ -3,6 +3,7 @@ set -m
 
 export REQUEST_DB_FILENAME=/data/requests.sqlite
 export USER_DB_FILENAME=/data/users.sqlite
+export HISTORY_DB_FILENAME=/data/history.sqlite
 export GOMEMLIMIT=160MiB
 export MALLOC_ARENA_MAX=4
 export OTEL_EXPORTER_OTLP_ENDPOINT="https://api.honeycomb.io"
@@ -25,7 +26,8 @@ backup() {
 }
 
 backup &
-pdns_server --config-dir=/etc/pdns &
+# ALIAS_RESOLVER is the same setting the Go server uses in local mode
+pdns_server --config-dir=/etc/pdns --resolver="${ALIAS_RESOLVER:-1.1.1.1:53}" &
 pdns_pid=$!
 
 /usr/bin/mess-with-dns &